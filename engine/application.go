@@ -1,36 +1,49 @@
 package engine
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"gopkg.in/yaml.v1"
+	"github.com/docker/go-units"
 	"github.com/jbdalido/smg/utils"
+	"gopkg.in/yaml.v1"
 )
 
 type Application struct {
 	ID           string
-	Name         string                   `yaml:"name"`
-	Image        string                   `yaml:"image"`
-	ImageFile    string                   `yaml:"image_dockerfile"`
-	Services     []string                 `yaml:"services"`
-	Applications map[string]*Application  `yaml:"applications"`
-	Ports        []string                 `yaml:"ports"`
-	Env          []string                 `yaml:"env"`
-	Volumes      []string                 `yaml:"volumes"`
-	Commands     map[string][]string      `yaml:"commands"`
-	System       map[string]*SystemConfig `yaml:"system"`
-	Builds       map[string]*Build        `yaml:"build"`
-	Environments map[string]*Application  `yaml:"environments"`
-	Entrypoint   string                   `yaml:"entrypoint"`
-	Cmd          []string                 `yaml:"cmd"`
-
+	Name         string                    `yaml:"name"`
+	Image        string                    `yaml:"image"`
+	ImageFile    string                    `yaml:"image_dockerfile"`
+	Services     []string                  `yaml:"services"`
+	Applications map[string]*Application   `yaml:"applications"`
+	Ports        []string                  `yaml:"ports"`
+	Env          []string                  `yaml:"env"`
+	Volumes      []string                  `yaml:"volumes"`
+	Commands     map[string][]string       `yaml:"commands"`
+	Dockerfiles  map[string]*DockerfileEnv `yaml:"dockerfiles"`
+	System       map[string]*SystemConfig  `yaml:"system"`
+	Builds       map[string]*Build         `yaml:"build"`
+	Deploys      map[string]*DeployTarget  `yaml:"deploy"`
+	Environments map[string]*Application   `yaml:"environments"`
+	Entrypoint   string                    `yaml:"entrypoint"`
+	Cmd          []string                  `yaml:"cmd"`
+	HealthCheck  *HealthCheck              `yaml:"healthcheck"`
+	DependsOn    []string                  `yaml:"depends_on"`
+	Artifacts    []*Artifact               `yaml:"artifacts"`
+	Timeout      time.Duration             `yaml:"timeout"`
+	Matrix       *Matrix                   `yaml:"matrix"`
+
+	AppName       string
+	RunID         string
 	Uptodate      bool
 	Hostname      string
 	FilePath      string
@@ -43,19 +56,79 @@ type Application struct {
 	UseDockerfile bool
 	NoCache       bool
 	ActiveBuild   *Build
+	BuildTarget   string
+	// Report is the file the result of
+	// each command is written to
+	Report string
+	// ForceTimeout is the --timeout flag,
+	// it wins over the smuggler file
+	ForceTimeout time.Duration
+	// StopTimeout is the time given to the
+	// containers to stop when interrupted
+	StopTimeout time.Duration
+	// MatrixFilter restricts the runs
+	// to the variants matching it
+	MatrixFilter string
+	// Variant of the matrix applied
+	// over the environment
+	Variant *MatrixVariant
+	// BuildArgs are the --build-arg flags,
+	// they win over the smuggler file
+	BuildArgs map[string]string
+	// Output is the file the build
+	// manifest is written to
+	Output string
+	// tagData is rendered in the tags of the build,
+	// computed once so that they match across images
+	tagData *TagData
 }
 
 type Build struct {
-	Push       bool     `yaml:"push"`
-	Deploy     []string `yaml:"deploy"`
-	Name       string   `yaml:"name"`
+	Push       bool              `yaml:"push"`
+	Deploy     []string          `yaml:"deploy"`
+	Name       string            `yaml:"name"`
+	Dockerfile string            `yaml:"dockerfile"`
+	Onlyif     string            `yaml:"onlyif"`
+	BuildArgs  map[string]string `yaml:"build_args"`
+	Labels     map[string]string `yaml:"labels"`
+	Target     string            `yaml:"target"`
+	Pull       bool              `yaml:"pull"`
+	Memory     string            `yaml:"memory"`
+	// Images replace name and dockerfile
+	// to build several images
+	Images []*ImageBuild `yaml:"images"`
+	// Tags are templates replacing the git tags,
+	// latest is added unless set to false
+	Tags   []string `yaml:"tags"`
+	Latest *bool    `yaml:"latest"`
+	// Semver floats major and minor tags to
+	// the releases tagged like v1.4.2
+	Semver bool `yaml:"semver"`
+}
+
+// DockerfileEnv is an environment run from a user
+// provided Dockerfile instead of a list of commands
+type DockerfileEnv struct {
 	Dockerfile string   `yaml:"dockerfile"`
-	Onlyif     string   `yaml:"onlyif"`
+	Entrypoint []string `yaml:"entrypoint"`
+	Cmd        []string `yaml:"cmd"`
 }
 
+// SystemConfig describes the system limits of a container,
+// ram and swap accept units (512m, 2g), plain numbers are megabytes
 type SystemConfig struct {
-	Cpu int
-	Ram int
+	Cpu  int64  `yaml:"cpu"`
+	Ram  string `yaml:"ram"`
+	Swap string `yaml:"swap"`
+	Pids int64  `yaml:"pids"`
+}
+
+// SystemLimits are the system limits applied to a container
+type SystemLimits struct {
+	CPUShares  int64
+	Memory     int64
+	MemorySwap int64
+	PidsLimit  int64
 }
 
 func (a *Application) Init() error {
@@ -86,6 +159,59 @@ func (a *Application) Init() error {
 	return nil
 }
 
+// Clone returns a fresh application loaded from the same
+// smuggler file with the same options, to run another environment
+func (a *Application) Clone() (*Application, error) {
+	c := &Application{
+		FilePath:      a.FilePath,
+		Repository:    a.Repository,
+		Uptodate:      a.Uptodate,
+		UseDockerfile: a.UseDockerfile,
+		NoCache:       a.NoCache,
+		KeepAlive:     a.KeepAlive,
+		Overrides:     a.Overrides,
+		Report:        a.Report,
+		ForceTimeout:  a.ForceTimeout,
+		StopTimeout:   a.StopTimeout,
+	}
+	err := c.Init()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RunnableEnvironments returns the sorted names of the environments
+// that can be run, with commands or a dockerfile
+func (a *Application) RunnableEnvironments() []string {
+	found := make(map[string]bool)
+	for n := range a.Commands {
+		found[n] = true
+	}
+	for n := range a.Dockerfiles {
+		found[n] = true
+	}
+	// Overlays can bring their own commands
+	for n, o := range a.Environments {
+		if o == nil {
+			continue
+		}
+		if _, ok := o.Commands[n]; ok {
+			found[n] = true
+		}
+		if _, ok := o.Dockerfiles[n]; ok {
+			found[n] = true
+		}
+	}
+
+	envs := make([]string, 0, len(found))
+	for n := range found {
+		envs = append(envs, n)
+	}
+	sort.Strings(envs)
+	return envs
+}
+
 // initialize the build
 // if tag not empty, force the build to use the tag (will still seek through regexp)
 func (a *Application) InitBuild(tag string) (string, error) {
@@ -95,6 +221,7 @@ func (a *Application) InitBuild(tag string) (string, error) {
 
 		if b, i := a.lookForBuild(tag, true); b != nil {
 			a.ActiveBuild = b
+			a.BuildTarget = i
 			return i, nil
 		}
 
@@ -106,6 +233,7 @@ func (a *Application) InitBuild(tag string) (string, error) {
 	if a.Git != nil {
 		if b, i := a.lookForBuild(a.Git.Branch, true); b != nil {
 			a.ActiveBuild = b
+			a.BuildTarget = i
 			return i, nil
 		}
 	}
@@ -113,6 +241,7 @@ func (a *Application) InitBuild(tag string) (string, error) {
 	// search for the default
 	if b, i := a.lookForBuild("default", false); b != nil {
 		a.ActiveBuild = b
+		a.BuildTarget = i
 		return i, nil
 	}
 
@@ -150,12 +279,65 @@ func (a *Application) BuildApplications() {
 
 	}
 
+	a.AppName = a.Name
 	a.Name = a.getServiceName(a.Image, a.Name, a.KeepAlive)
 	a.Hostname = a.getHostname(a.Image)
 	a.Image = a.getImageName(a.Image)
 
 }
 
+// SortApplications returns the applications names ordered
+// so that each application comes after its dependencies
+func (a *Application) SortApplications() ([]string, error) {
+	names := make([]string, 0, len(a.Applications))
+	for n := range a.Applications {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var (
+		sorted  []string
+		visited = make(map[string]bool)
+		path    []string
+		visit   func(n string) error
+	)
+
+	visit = func(n string) error {
+		if visited[n] {
+			return nil
+		}
+		for i, p := range path {
+			if p == n {
+				return fmt.Errorf("Dependency cycle between applications: %s -> %s",
+					strings.Join(path[i:], " -> "), n)
+			}
+		}
+		path = append(path, n)
+		for _, dep := range a.Applications[n].DependsOn {
+			if _, ok := a.Applications[dep]; !ok {
+				return fmt.Errorf("Application %s depends on unknown application %s", n, dep)
+			}
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		visited[n] = true
+		sorted = append(sorted, n)
+		return nil
+	}
+
+	for _, n := range names {
+		err := visit(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
 func (a *Application) HasDockerfile() bool {
 	_, err := utils.OpenFile(a.WorkingDir + "/Dockerfile")
 	if err != nil {
@@ -164,6 +346,18 @@ func (a *Application) HasDockerfile() bool {
 	return true
 }
 
+// newRunID returns a short random id identifying a run,
+// runs started at the same time get different ids
+func newRunID() string {
+	b := make([]byte, 4)
+	_, err := crand.Read(b)
+	if err != nil {
+		rand.Seed(time.Now().UTC().UnixNano())
+		return fmt.Sprintf("%08x", rand.Uint32())
+	}
+	return hex.EncodeToString(b)
+}
+
 func (a *Application) getServiceName(service string, appName string, keepalive bool) string {
 	serviceName := a.getHostname(service)
 	hostname := a.getHostname(appName)
@@ -171,8 +365,12 @@ func (a *Application) getServiceName(service string, appName string, keepalive b
 		return ""
 	}
 	name := fmt.Sprintf("%s-%s", serviceName, hostname)
-	// If keepalive is on we want to simply match services names
+	// If keepalive is on we want to simply match services names,
+	// otherwise the run id isolates runs of the same application
 	if !keepalive {
+		if a.RunID != "" {
+			return name + "-" + a.RunID
+		}
 		rand.Seed(time.Now().UTC().UnixNano())
 		name += "-" + strconv.Itoa(rand.Intn(10000)+30000)
 	}
@@ -210,17 +408,206 @@ func (a *Application) getHostname(image string) string {
 
 func (a *Application) SetEnv(env string) error {
 
-	if _, ok := a.Commands[env]; !ok {
+	// Environments are overlays of the application
+	a.ApplyEnvironment(env)
+
+	// And the variant of the matrix wins over both
+	if a.Variant != nil {
+		a.Merge(a.Variant.Application())
+	}
+
+	// Dockerfiles are run instead of commands if they exist
+	if df := a.GetDockerfileEnv(env); df != nil {
+		if df.Dockerfile == "" {
+			return fmt.Errorf("Environment %s has no dockerfile in %s", env, a.FilePath)
+		}
+		// The image is built from the context, shared folder can't be used
+		a.UseDockerfile = true
+	} else if _, ok := a.Commands[env]; !ok {
 		return fmt.Errorf("Environment %s not found in %s", env, a.FilePath)
 	}
 
+	if err := a.CheckDurations(); err != nil {
+		return err
+	}
+
 	a.Environment = env
 
+	if a.RunID == "" {
+		a.RunID = newRunID()
+	}
+
 	a.BuildApplications()
 
 	return nil
 }
 
+// ApplyEnvironment deep-merges the environment block
+// matching env over the application, if it exists
+func (a *Application) ApplyEnvironment(env string) {
+	overlay, ok := a.Environments[env]
+	if !ok || overlay == nil {
+		return
+	}
+	log.Debugf("Applying environment %s", env)
+	a.Merge(overlay)
+}
+
+// Merge overrides the application with the values set in o,
+// lists are merged and maps are merged by key
+func (a *Application) Merge(o *Application) {
+	if o.Name != "" {
+		a.Name = o.Name
+	}
+	if o.Image != "" {
+		a.Image = o.Image
+	}
+	if o.ImageFile != "" {
+		a.ImageFile = o.ImageFile
+	}
+	if o.Entrypoint != "" {
+		a.Entrypoint = o.Entrypoint
+	}
+	if len(o.Cmd) > 0 {
+		a.Cmd = o.Cmd
+	}
+	if o.HealthCheck != nil {
+		a.HealthCheck = o.HealthCheck
+	}
+	if len(o.DependsOn) > 0 {
+		a.DependsOn = o.DependsOn
+	}
+	if len(o.Artifacts) > 0 {
+		a.Artifacts = o.Artifacts
+	}
+	if o.Timeout != 0 {
+		a.Timeout = o.Timeout
+	}
+	if o.Matrix != nil {
+		a.Matrix = o.Matrix
+	}
+
+	a.Services = mergeList(a.Services, o.Services)
+	a.Ports = mergeList(a.Ports, o.Ports)
+	a.Volumes = mergeList(a.Volumes, o.Volumes)
+	a.Env = mergeEnv(a.Env, o.Env)
+
+	for n, app := range o.Applications {
+		if a.Applications == nil {
+			a.Applications = make(map[string]*Application)
+		}
+		if app == nil {
+			a.Applications[n] = nil
+			continue
+		}
+		// The overlay is copied, later merges must not change it
+		if a.Applications[n] == nil {
+			a.Applications[n] = &Application{}
+		}
+		a.Applications[n].Merge(app)
+	}
+
+	for n, sys := range o.System {
+		if a.System == nil {
+			a.System = make(map[string]*SystemConfig)
+		}
+		if a.System[n] != nil && sys != nil {
+			if sys.Cpu != 0 {
+				a.System[n].Cpu = sys.Cpu
+			}
+			if sys.Ram != "" {
+				a.System[n].Ram = sys.Ram
+			}
+			if sys.Swap != "" {
+				a.System[n].Swap = sys.Swap
+			}
+			if sys.Pids != 0 {
+				a.System[n].Pids = sys.Pids
+			}
+			continue
+		}
+		if sys != nil {
+			copied := *sys
+			sys = &copied
+		}
+		a.System[n] = sys
+	}
+
+	for n, cmds := range o.Commands {
+		if a.Commands == nil {
+			a.Commands = make(map[string][]string)
+		}
+		a.Commands[n] = cmds
+	}
+
+	for n, df := range o.Dockerfiles {
+		if a.Dockerfiles == nil {
+			a.Dockerfiles = make(map[string]*DockerfileEnv)
+		}
+		a.Dockerfiles[n] = df
+	}
+
+	for n, b := range o.Builds {
+		if a.Builds == nil {
+			a.Builds = make(map[string]*Build)
+		}
+		a.Builds[n] = b
+	}
+
+	for n, t := range o.Deploys {
+		if a.Deploys == nil {
+			a.Deploys = make(map[string]*DeployTarget)
+		}
+		a.Deploys[n] = t
+	}
+}
+
+// mergeList appends the values of o missing from l
+func mergeList(l []string, o []string) []string {
+	for _, v := range o {
+		found := false
+		for _, e := range l {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// mergeEnv merges KEY=VALUE variables, o overrides
+// the values of l sharing the same key
+func mergeEnv(l []string, o []string) []string {
+	for _, v := range o {
+		key := strings.SplitN(v, "=", 2)[0]
+		found := false
+		for i, e := range l {
+			if strings.SplitN(e, "=", 2)[0] == key {
+				l[i] = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// GetDockerfileEnv returns the dockerfile definition
+// of the environment, or nil if the environment uses commands
+func (a *Application) GetDockerfileEnv(env string) *DockerfileEnv {
+	if df, ok := a.Dockerfiles[env]; ok && df != nil {
+		return df
+	}
+	return nil
+}
+
 func (a *Application) SetOverrides(overrides []string) error {
 	if len(overrides) > 0 {
 		a.Overrides = make(map[string]string)
@@ -256,22 +643,64 @@ func (a *Application) GetSubscriptions() []string {
 	return nil
 }
 
-func (a *Application) GetSystemLimits(service string) (int, int) {
-	// Set some default low ressources
-	var (
-		cpu = 1
-		ram = 512
-	)
-	if a.System[service] != nil {
+// GetSystemLimits returns the limits set for the service, services
+// are matched by hostname and the main container by application name.
+// Zero values are left to the docker daemon defaults
+func (a *Application) GetSystemLimits(service string) (SystemLimits, error) {
+	limits := SystemLimits{}
+
+	sys := a.System[service]
+	if sys == nil {
+		return limits, nil
+	}
+
+	if sys.Cpu < 0 {
+		return limits, fmt.Errorf("Invalid cpu %d for %s", sys.Cpu, service)
+	}
+	limits.CPUShares = sys.Cpu
+
+	if sys.Pids < 0 {
+		return limits, fmt.Errorf("Invalid pids %d for %s", sys.Pids, service)
+	}
+	limits.PidsLimit = sys.Pids
+
+	ram, err := parseMemory(sys.Ram)
+	if err != nil || ram < 0 {
+		return limits, fmt.Errorf("Invalid ram %s for %s", sys.Ram, service)
+	}
+	limits.Memory = ram
 
-		if a.System[service].Cpu != 0 {
-			cpu = a.System[service].Cpu
+	// Swap is memory plus swap, -1 means unlimited swap
+	swap, err := parseMemory(sys.Swap)
+	if err != nil || swap < -1 {
+		return limits, fmt.Errorf("Invalid swap %s for %s", sys.Swap, service)
+	}
+	if swap != 0 {
+		if ram == 0 {
+			return limits, fmt.Errorf("Swap for %s can't be set without ram", service)
 		}
-		if a.System[service].Ram != 0 {
-			ram = a.System[service].Ram
+		if swap > 0 && swap < ram {
+			return limits, fmt.Errorf("Swap for %s (%s) must be greater than ram (%s)", service, sys.Swap, sys.Ram)
 		}
 	}
-	return cpu, ram
+	limits.MemorySwap = swap
+
+	return limits, nil
+}
+
+// parseMemory reads a memory size such as 512m or 2g,
+// a plain number is read as megabytes
+func parseMemory(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	if size == "-1" {
+		return -1, nil
+	}
+	if mb, err := strconv.ParseInt(size, 10, 64); err == nil {
+		return mb * 1024 * 1024, nil
+	}
+	return units.RAMInBytes(size)
 }
 
 // look for a build
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v1"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	a := &Application{
+		Name:     "smuggler",
+		Image:    "debian:jessie",
+		Env:      []string{"A=1", "B=2"},
+		Ports:    []string{"8000:80"},
+		Services: []string{"mongo"},
+		Applications: map[string]*Application{
+			"cassandra": {Image: "cassandra", Ports: []string{"9042"}},
+		},
+		Environments: map[string]*Application{
+			"ci": {
+				Image:    "ubuntu:14.04",
+				Env:      []string{"B=3", "C=4"},
+				Ports:    []string{"8000:80", "443"},
+				Services: []string{"redis"},
+				Applications: map[string]*Application{
+					"cassandra": {Env: []string{"HEAP=1g"}},
+					"mysql":     {Image: "mysql"},
+				},
+				System: map[string]*SystemConfig{
+					"smuggler": {Cpu: 512},
+				},
+			},
+		},
+	}
+
+	a.ApplyEnvironment("unknown")
+	if a.Image != "debian:jessie" {
+		t.Errorf("Unknown environment should not change the application")
+	}
+
+	a.ApplyEnvironment("ci")
+	if a.Image != "ubuntu:14.04" {
+		t.Errorf("Image not overridden, got %s", a.Image)
+	}
+	if !reflect.DeepEqual(a.Env, []string{"A=1", "B=3", "C=4"}) {
+		t.Errorf("Env not merged, got %v", a.Env)
+	}
+	if !reflect.DeepEqual(a.Ports, []string{"8000:80", "443"}) {
+		t.Errorf("Ports not merged, got %v", a.Ports)
+	}
+	if !reflect.DeepEqual(a.Services, []string{"mongo", "redis"}) {
+		t.Errorf("Services not merged, got %v", a.Services)
+	}
+	if a.Applications["cassandra"].Image != "cassandra" || len(a.Applications["cassandra"].Env) != 1 {
+		t.Errorf("Application cassandra not deep merged")
+	}
+	if a.Applications["mysql"] == nil {
+		t.Errorf("Application mysql not added")
+	}
+	if a.System["smuggler"] == nil || a.System["smuggler"].Cpu != 512 {
+		t.Errorf("System limits not merged")
+	}
+
+	// Applying twice should not duplicate anything
+	a.ApplyEnvironment("ci")
+	if len(a.Env) != 3 || len(a.Ports) != 2 || len(a.Services) != 2 {
+		t.Errorf("Environment is not idempotent")
+	}
+
+	// The overlays are copied, not shared with the application
+	a.Applications["mysql"].Image = "mariadb"
+	a.System["smuggler"].Cpu = 1024
+	ci := a.Environments["ci"]
+	if ci.Applications["mysql"].Image != "mysql" || ci.System["smuggler"].Cpu != 512 {
+		t.Errorf("Environment ci changed by the application")
+	}
+}
+
+func TestGetSystemLimits(t *testing.T) {
+	a := &Application{
+		System: map[string]*SystemConfig{
+			"smuggler":  {Cpu: 512, Ram: "512", Swap: "2g", Pids: 100},
+			"cassandra": {Ram: "1g", Swap: "-1"},
+			"badswap":   {Ram: "1g", Swap: "512m"},
+			"noram":     {Swap: "1g"},
+			"badunit":   {Ram: "12zb"},
+		},
+	}
+
+	limits, err := a.GetSystemLimits("smuggler")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := SystemLimits{
+		CPUShares:  512,
+		Memory:     512 * 1024 * 1024,
+		MemorySwap: 2 * 1024 * 1024 * 1024,
+		PidsLimit:  100,
+	}
+	if limits != expected {
+		t.Errorf("Expected %+v, got %+v", expected, limits)
+	}
+
+	limits, err = a.GetSystemLimits("cassandra")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if limits.Memory != 1024*1024*1024 || limits.MemorySwap != -1 {
+		t.Errorf("Unexpected limits %+v", limits)
+	}
+
+	limits, err = a.GetSystemLimits("mongo")
+	if err != nil || limits != (SystemLimits{}) {
+		t.Errorf("Undefined service should not be limited")
+	}
+
+	for _, service := range []string{"badswap", "noram", "badunit"} {
+		if _, err := a.GetSystemLimits(service); err == nil {
+			t.Errorf("Expected an error for %s", service)
+		}
+	}
+}
+
+func TestSortApplications(t *testing.T) {
+	a := &Application{
+		Applications: map[string]*Application{
+			"api":       {DependsOn: []string{"cassandra", "redis"}},
+			"cassandra": {},
+			"redis":     {},
+			"worker":    {DependsOn: []string{"api"}},
+		},
+	}
+
+	order, err := a.SortApplications()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := []string{"cassandra", "redis", "api", "worker"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+
+	a.Applications["cassandra"].DependsOn = []string{"worker"}
+	_, err = a.SortApplications()
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+
+	a.Applications["cassandra"].DependsOn = []string{"mysql"}
+	_, err = a.SortApplications()
+	if err == nil || !strings.Contains(err.Error(), "unknown application mysql") {
+		t.Errorf("Expected an unknown application error, got %v", err)
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	a := &Application{}
+	err := yaml.Unmarshal([]byte("name: smuggler\ntimeout: 30m\nenvironments:\n  ci:\n    timeout: 10m\n"), a)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if a.GetTimeout() != 30*time.Minute {
+		t.Errorf("Expected a 30m timeout, got %s", a.GetTimeout())
+	}
+
+	a.ApplyEnvironment("ci")
+	if a.GetTimeout() != 10*time.Minute {
+		t.Errorf("Environment timeout not applied, got %s", a.GetTimeout())
+	}
+
+	a.ForceTimeout = time.Minute
+	if a.GetTimeout() != time.Minute {
+		t.Errorf("Timeout flag should win, got %s", a.GetTimeout())
+	}
+}
+
+func TestCheckDurations(t *testing.T) {
+	a := &Application{}
+	err := yaml.Unmarshal([]byte("name: smuggler\ntimeout: 30m\nhealthcheck:\n  interval: 2s\n"), a)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := a.CheckDurations(); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	// Without unit yaml gives nanoseconds
+	for _, conf := range []string{
+		"timeout: 30",
+		"healthcheck:\n  timeout: 5",
+		"applications:\n  mongo:\n    healthcheck:\n      interval: 1",
+	} {
+		a := &Application{}
+		if err := yaml.Unmarshal([]byte("name: smuggler\n"+conf+"\n"), a); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if err := a.CheckDurations(); err == nil || !strings.Contains(err.Error(), "unit") {
+			t.Errorf("Expected an error for %q, got %v", conf, err)
+		}
+	}
+}
+
+func TestRunnableEnvironments(t *testing.T) {
+	a := &Application{
+		Commands: map[string][]string{
+			"unit": {"make test"},
+			"lint": {"make lint"},
+		},
+		Dockerfiles: map[string]*DockerfileEnv{
+			"integration": {Dockerfile: "Dockerfile.it"},
+		},
+		Environments: map[string]*Application{
+			// Overlays only add commands for their own environment
+			"e2e":  {Commands: map[string][]string{"e2e": {"make e2e"}}},
+			"prod": {Env: []string{"PROD=1"}},
+		},
+	}
+
+	envs := a.RunnableEnvironments()
+	expected := []string{"e2e", "integration", "lint", "unit"}
+	if !reflect.DeepEqual(envs, expected) {
+		t.Errorf("Expected %v, got %v", expected, envs)
+	}
+}
+
+func TestGetServiceName(t *testing.T) {
+	a := &Application{RunID: "0a1b2c3d"}
+	if name := a.getServiceName("mongo", "smuggler", false); name != "mongo-smuggler-0a1b2c3d" {
+		t.Errorf("Expected the run id as suffix, got %s", name)
+	}
+	if name := a.getServiceName("mongo", "smuggler", true); name != "mongo-smuggler" {
+		t.Errorf("Expected no suffix with keepalive, got %s", name)
+	}
+
+	b := &Application{RunID: newRunID()}
+	if a.RunID == b.RunID || b.getServiceName("mongo", "smuggler", false) == a.getServiceName("mongo", "smuggler", false) {
+		t.Errorf("Runs should get different names")
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// When artifacts are extracted
+const (
+	ARTIFACTS_SUCCESS = "success"
+	ARTIFACTS_FAILURE = "failure"
+	ARTIFACTS_ALWAYS  = "always"
+)
+
+// Artifact is a path of the controller copied to the host after
+// the run, the path accepts globs and is relative to the working
+// directory of the container, dest is relative to the smuggler file
+type Artifact struct {
+	Path string `yaml:"path"`
+	Dest string `yaml:"dest"`
+	When string `yaml:"when"`
+}
+
+// Match tells if the artifact is extracted after
+// a successful or failed run, default is on success
+func (a *Artifact) Match(success bool) (bool, error) {
+	switch a.When {
+	case "", ARTIFACTS_SUCCESS:
+		return success, nil
+	case ARTIFACTS_FAILURE:
+		return !success, nil
+	case ARTIFACTS_ALWAYS:
+		return true, nil
+	}
+	return false, fmt.Errorf("Invalid when %s for artifact %s, expecting %s, %s or %s", a.When, a.Path, ARTIFACTS_SUCCESS, ARTIFACTS_FAILURE, ARTIFACTS_ALWAYS)
+}
+
+// ExtractArtifacts copies the artifacts out of the controller,
+// it has to run before the containers are removed
+func (e *Engine) ExtractArtifacts(success bool) error {
+
+	c := e.Docker.Controller
+	if len(e.App.Artifacts) == 0 || c == nil || c.Docker == nil {
+		return nil
+	}
+
+	// Nothing to extract if the controller never started
+	err := c.Inspect(c.Docker.ID)
+	if err != nil {
+		return err
+	}
+	if c.Docker.State.StartedAt.IsZero() {
+		return nil
+	}
+
+	failed := 0
+	for _, artifact := range e.App.Artifacts {
+		ok, err := artifact.Match(success)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		dest := artifact.Dest
+		if dest == "" {
+			dest = "."
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(e.App.WorkingDir, dest)
+		}
+
+		n, err := c.Download(artifact.Path, dest)
+		if err != nil {
+			log.Errorf("Error - Cannot extract artifact %s: %s", artifact.Path, err)
+			failed++
+			continue
+		}
+		if n == 0 {
+			log.Warnf("Artifact %s matched no file", artifact.Path)
+			continue
+		}
+		log.Infof("--> Extracted %d files of %s to %s", n, artifact.Path, dest)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d artifacts could not be extracted", failed)
+	}
+	return nil
+}
+
+// Download copies the files of the container matching the
+// pattern to dest with the archive API, returns the number of files
+func (c *Container) Download(pattern string, dest string) (int, error) {
+
+	if !path.IsAbs(pattern) {
+		workdir := "/"
+		if c.Docker.Config != nil && c.Docker.Config.WorkingDir != "" {
+			workdir = c.Docker.Config.WorkingDir
+		}
+		pattern = path.Join(workdir, pattern)
+	}
+	pattern = path.Clean(pattern)
+
+	// The archive API doesn't know globs, the deepest
+	// directory without any is downloaded and filtered
+	base, root := globBase(pattern)
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(c.Client.DownloadFromContainer(c.Docker.ID, dockerclient.DownloadFromContainerOptions{
+			Path:         base,
+			OutputStream: writer,
+		}))
+	}()
+	defer reader.Close()
+
+	n := 0
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		// Entries are named from the parent of the base
+		name := path.Join(path.Dir(base), header.Name)
+		if !matchArtifact(pattern, name) {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		if rel == "" {
+			rel = path.Base(name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+		if r, err := filepath.Rel(dest, target); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(os.PathSeparator)) {
+			return n, fmt.Errorf("Invalid path %s in archive", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeArtifact(target, archive, os.FileMode(header.Mode))
+			n++
+		default:
+			log.Debugf("Skipping %s, not a regular file", name)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// globBase returns the deepest directory of the pattern without
+// globs, and the root the extracted paths are relative to
+func globBase(pattern string) (string, string) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern, path.Dir(pattern)
+	}
+	base := pattern
+	for strings.ContainsAny(base, "*?[") {
+		base = path.Dir(base)
+	}
+	return base, base
+}
+
+// matchArtifact tells if the path of the container is matched
+// by the pattern, or is inside a matched directory
+func matchArtifact(pattern string, name string) bool {
+	for p := name; p != "/" && p != "."; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func writeArtifact(target string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestGlobBase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		root    string
+	}{
+		{"/data/coverage", "/data/coverage", "/data"},
+		{"/data/report.xml", "/data/report.xml", "/data"},
+		{"/data/coverage/*.out", "/data/coverage", "/data/coverage"},
+		{"/data/*/junit.xml", "/data", "/data"},
+	}
+	for _, test := range tests {
+		base, root := globBase(test.pattern)
+		if base != test.base || root != test.root {
+			t.Errorf("Expected %s %s for %s, got %s %s", test.base, test.root, test.pattern, base, root)
+		}
+	}
+}
+
+func TestMatchArtifact(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"/data/coverage", "/data/coverage/index.html", true},
+		{"/data/coverage/*.out", "/data/coverage/unit.out", true},
+		{"/data/coverage/*.out", "/data/coverage/unit.txt", false},
+		{"/data/*/junit.xml", "/data/api/junit.xml", true},
+		{"/data/*/junit.xml", "/data/api/sub/junit.xml", false},
+		{"/data/build", "/data/builds", false},
+	}
+	for _, test := range tests {
+		if matchArtifact(test.pattern, test.name) != test.match {
+			t.Errorf("Expected %v for %s with %s", test.match, test.name, test.pattern)
+		}
+	}
+}
+
+func TestArtifactMatch(t *testing.T) {
+	tests := []struct {
+		when    string
+		success bool
+		match   bool
+	}{
+		{"", true, true},
+		{"", false, false},
+		{ARTIFACTS_FAILURE, false, true},
+		{ARTIFACTS_FAILURE, true, false},
+		{ARTIFACTS_ALWAYS, false, true},
+	}
+	for _, test := range tests {
+		a := &Artifact{Path: "/data/out", When: test.when}
+		match, err := a.Match(test.success)
+		if err != nil || match != test.match {
+			t.Errorf("Expected %v for when %q and success %v, got %v %v", test.match, test.when, test.success, match, err)
+		}
+	}
+
+	a := &Artifact{Path: "/data/out", When: "sometimes"}
+	if _, err := a.Match(true); err == nil {
+		t.Errorf("Expected an error for an invalid when")
+	}
+}
@@ -4,10 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -16,6 +15,9 @@ import (
 	"github.com/jbdalido/smg/utils"
 )
 
+// Temporary build contexts are copied under this prefix
+const BUILD_TMP = "/tmp/smg/build"
+
 //
 type Builder struct {
 	Client   *dockerclient.Client
@@ -25,6 +27,10 @@ type Builder struct {
 	rootPath string
 	Hostname string
 	Privates map[string]AuthConfig
+	// Labels are added to the built images
+	Labels map[string]string
+	// Options of the build definition
+	Options BuildOptions
 }
 
 // Dockerfile represent an actual Dockerfile to write
@@ -51,7 +57,15 @@ func NewBuilder(p string, client *dockerclient.Client) *Builder {
 		return nil
 	}
 
-	tmpPath := "/tmp/smg/build" + strconv.Itoa(rand.Intn(10000)+30000)
+	// Each builder gets its own folder, runs can build at the same time
+	err = os.MkdirAll(filepath.Dir(BUILD_TMP), 0755)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	tmpPath, err := ioutil.TempDir(filepath.Dir(BUILD_TMP), filepath.Base(BUILD_TMP))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
 
 	// Copy with tar stream
 	err = archive.CopyWithTar(path, tmpPath)
@@ -189,7 +203,9 @@ func (b *Builder) WriteFile(path string, data []byte) error {
 	return nil
 }
 
-func (b *Builder) WriteRunScript(name string, lines []string, sharedDirectory bool) error {
+// WriteRunScript writes the commands in a script, with report
+// each command is surrounded by markers to be timed individually
+func (b *Builder) WriteRunScript(name string, lines []string, sharedDirectory bool, report bool) error {
 	// Write a run.sh script
 	if len(lines) > 0 {
 		// Setup run script line with friendly docker script
@@ -198,8 +214,12 @@ func (b *Builder) WriteRunScript(name string, lines []string, sharedDirectory bo
 		commands.WriteString("#!/bin/bash\n")
 		// Exit immediately if a command exits with a non-zero status.
 		commands.WriteString("set -e\n")
-		for _, line := range lines {
-			commands.WriteString(fmt.Sprintf("%s\n", line))
+		if report {
+			commands.WriteString(reportScript(lines))
+		} else {
+			for _, line := range lines {
+				commands.WriteString(fmt.Sprintf("%s\n", line))
+			}
 		}
 
 		// Setup the right path to write the run script
@@ -287,11 +307,31 @@ func (b *Builder) MakeImage(dockerfile string, name ImageName, uptodate bool, no
 	// Prevent cleanup of directories
 	defer b.Cleanup()
 
-	_, err := utils.OpenAndReadFile(b.Path + "/" + dockerfile)
+	content, err := utils.OpenAndReadFile(b.Path + "/" + dockerfile)
 	if err != nil {
 		return fmt.Errorf("%s does not exist", dockerfile)
 	}
 
+	// The stages after the target are left out, the
+	// labels are then set on the target stage
+	if b.Options.Target != "" {
+		content, err = targetStage(content, b.Options.Target)
+		if err != nil {
+			return fmt.Errorf("%s in %s", err, dockerfile)
+		}
+	}
+
+	// Labels are set on the last stage of the copied Dockerfile
+	if len(b.Labels) > 0 {
+		content = append(endInstruction(content), []byte(labelInstruction(b.Labels)+"\n")...)
+	}
+	if len(b.Labels) > 0 || b.Options.Target != "" {
+		err = b.WriteFile(b.Path+"/"+dockerfile, content)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Tar the current path since
 	// the Dockerfile is here
 	tarDir, err := archive.Tar(b.Path, 0)
@@ -316,6 +356,9 @@ func (b *Builder) MakeImage(dockerfile string, name ImageName, uptodate bool, no
 		InputStream: tarDir,
 		NoCache:     nocache,
 		Dockerfile:  dockerfile,
+		BuildArgs:   b.Options.buildArgs(),
+		Pull:        b.Options.Pull,
+		Memory:      b.Options.Memory,
 	}
 
 	if utils.IsVerbose() {
@@ -346,9 +389,9 @@ func (b *Builder) MakeImage(dockerfile string, name ImageName, uptodate bool, no
 
 }
 
-// Push the docker images
-//
-func (b *Builder) PushImage(name ImageName) error {
+// Push the docker images, the digest
+// given by the registry is returned
+func (b *Builder) PushImage(name ImageName) (string, error) {
 
 	auth := dockerclient.AuthConfiguration{}
 	if _, ok := b.Privates[name.Registry]; ok {
@@ -358,27 +401,34 @@ func (b *Builder) PushImage(name ImageName) error {
 		}
 	}
 
-	// Push all the tags if they exist
+	// Push all the tags if they exist, they
+	// share the digest of the same image
+	digest := ""
 	if len(name.Tags) > 0 {
 		for _, tag := range name.Tags {
 
 			// Setup push options for docker client
+			var output bytes.Buffer
 			pushOptions := dockerclient.PushImageOptions{
-				Name: name.Name,
-				Tag:  tag,
+				Name:         name.Name,
+				Tag:          tag,
+				OutputStream: &output,
 			}
 
 			// Let's push
 			log.Infof("Pushing %s:%s", name.Name, tag)
 			err := b.Client.PushImage(pushOptions, auth)
 			if err != nil {
-				return err
+				return "", err
+			}
+			if d := pushDigest(output.String()); d != "" {
+				digest = d
 			}
 			log.Infof("-->  Push succeed %s", name.Name)
 		}
 	}
 
-	return nil
+	return digest, nil
 }
 
 func (b *Builder) PullImage(name ImageName) error {
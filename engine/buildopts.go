@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
+)
+
+// BuildOptions are the options given to docker
+// build by the active build definition
+type BuildOptions struct {
+	Args   map[string]string
+	Target string
+	Pull   bool
+	Memory int64
+}
+
+// GetBuildOptions returns the options of the active build, build
+// args are resolved against the environment and the --build-arg
+// flags win over the ones of the smuggler file
+func (a *Application) GetBuildOptions() (BuildOptions, error) {
+	opts := BuildOptions{}
+	if a.ActiveBuild == nil {
+		return opts, nil
+	}
+
+	opts.Target = a.ActiveBuild.Target
+	opts.Pull = a.ActiveBuild.Pull
+
+	memory, err := parseMemory(a.ActiveBuild.Memory)
+	if err != nil || memory < 0 {
+		return opts, fmt.Errorf("Invalid memory %s for the build", a.ActiveBuild.Memory)
+	}
+	opts.Memory = memory
+
+	opts.Args = make(map[string]string)
+	for k, v := range a.ActiveBuild.BuildArgs {
+		opts.Args[k] = utils.EnvResolver(v)
+	}
+	for k, v := range a.BuildArgs {
+		opts.Args[k] = v
+	}
+
+	return opts, nil
+}
+
+// ParseBuildArgs reads KEY=VALUE build args, a KEY
+// alone takes its value from the environment
+func ParseBuildArgs(args []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("Invalid build arg %s, expected KEY=VALUE", arg)
+		}
+		if len(kv) == 1 {
+			value, ok := os.LookupEnv(kv[0])
+			if !ok {
+				return nil, fmt.Errorf("Build arg %s is not set in the environment", kv[0])
+			}
+			kv = append(kv, value)
+		}
+		parsed[kv[0]] = kv[1]
+	}
+	return parsed, nil
+}
+
+// buildArgs returns the args sorted by name,
+// as expected by the docker client
+func (o BuildOptions) buildArgs() []dockerclient.BuildArg {
+	keys := make([]string, 0, len(o.Args))
+	for k := range o.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []dockerclient.BuildArg
+	for _, k := range keys {
+		args = append(args, dockerclient.BuildArg{Name: k, Value: o.Args[k]})
+	}
+	return args
+}
+
+// targetStage cuts the Dockerfile after the stage named target,
+// the stages following it are not needed to build it
+func targetStage(content []byte, target string) ([]byte, error) {
+	var out bytes.Buffer
+	found := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			if found {
+				break
+			}
+			n := len(fields)
+			if n >= 4 && strings.EqualFold(fields[n-2], "AS") && strings.EqualFold(fields[n-1], target) {
+				found = true
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Target stage %s not found", target)
+	}
+	return out.Bytes(), nil
+}
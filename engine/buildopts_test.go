@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetBuildOptions(t *testing.T) {
+	os.Setenv("SMG_TEST_VERSION", "1.2.3")
+	defer os.Unsetenv("SMG_TEST_VERSION")
+
+	a := &Application{
+		ActiveBuild: &Build{
+			BuildArgs: map[string]string{
+				"VERSION": "$SMG_TEST_VERSION",
+				"MODE":    "release",
+			},
+			Target: "prod",
+			Pull:   true,
+			Memory: "512m",
+		},
+		BuildArgs: map[string]string{"MODE": "debug"},
+	}
+
+	opts, err := a.GetBuildOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"VERSION": "1.2.3", "MODE": "debug"}
+	if !reflect.DeepEqual(opts.Args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, opts.Args)
+	}
+	if opts.Target != "prod" || !opts.Pull || opts.Memory != 512*1024*1024 {
+		t.Errorf("Options don't match the build: %+v", opts)
+	}
+	if args := opts.buildArgs(); len(args) != 2 || args[0].Name != "MODE" {
+		t.Errorf("Expected args sorted by name, got %v", args)
+	}
+
+	a.ActiveBuild.Memory = "lots"
+	if _, err := a.GetBuildOptions(); err == nil {
+		t.Errorf("Expected an error for an invalid memory")
+	}
+}
+
+func TestParseBuildArgs(t *testing.T) {
+	os.Setenv("SMG_TEST_TOKEN", "secret")
+	defer os.Unsetenv("SMG_TEST_TOKEN")
+
+	args, err := ParseBuildArgs([]string{"A=1", "B=x=y", "SMG_TEST_TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"A": "1", "B": "x=y", "SMG_TEST_TOKEN": "secret"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+
+	for _, arg := range []string{"=1", "SMG_TEST_UNSET"} {
+		if _, err := ParseBuildArgs([]string{arg}); err == nil {
+			t.Errorf("Expected an error for %s", arg)
+		}
+	}
+}
+
+func TestTargetStage(t *testing.T) {
+	dockerfile := "FROM golang:1.8 AS build\nRUN make\n\nfrom debian:jessie as prod\nCOPY --from=build /app /app\nFROM prod AS debug\nRUN apt-get install gdb\n"
+
+	content, err := targetStage([]byte(dockerfile), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "FROM golang:1.8 AS build\nRUN make\n\nfrom debian:jessie as prod\nCOPY --from=build /app /app\n"
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, content)
+	}
+
+	content, err = targetStage([]byte(dockerfile), "debug")
+	if err != nil || string(content) != dockerfile {
+		t.Errorf("The last stage should keep the whole Dockerfile, got %q %v", content, err)
+	}
+
+	if _, err := targetStage([]byte(dockerfile), "test"); err == nil {
+		t.Errorf("Expected an error for an unknown stage")
+	}
+}
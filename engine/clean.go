@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// CleanOptions selects the leftovers removed by Clean
+type CleanOptions struct {
+	DryRun    bool
+	OlderThan time.Duration
+	KeepAlive bool
+	Running   bool
+}
+
+// Clean removes the containers, images, networks and temporary
+// build directories left behind by interrupted runs
+func (e *Engine) Clean(opts CleanOptions) error {
+
+	err := e.Docker.Connect()
+	if err != nil {
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
+	}
+
+	cutoff := time.Now().Add(-opts.OlderThan)
+	action := "Removed"
+	if opts.DryRun {
+		action = "Would remove"
+	}
+
+	var removed, failed int
+
+	// Containers first, images can't be removed while used
+	containers, err := e.Docker.ListContainers(true)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		name := c.ID[:12]
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		if !isRunContainer(c) || time.Unix(c.Created, 0).After(cutoff) {
+			continue
+		}
+		if opts.KeepAlive && c.Labels[LABEL_KEEPALIVE] == "true" {
+			log.Debugf("Keeping container %s", name)
+			continue
+		}
+		running := c.State == "running" || strings.HasPrefix(c.Status, "Up")
+		if running && !opts.Running {
+			log.Infof("Skipping running container %s", name)
+			continue
+		}
+
+		if !opts.DryRun {
+			err := e.Docker.Client.RemoveContainer(dockerclient.RemoveContainerOptions{
+				ID:            c.ID,
+				Force:         running,
+				RemoveVolumes: true,
+			})
+			if err != nil {
+				log.Errorf("Error - Cannot remove container %s: %s", name, err)
+				failed++
+				continue
+			}
+		}
+		log.Infof("%s container %s", action, name)
+		removed++
+	}
+
+	// Run images are tagged stmp
+	images, err := e.Docker.Client.ListImages(dockerclient.ListImagesOptions{})
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		repo := runImageRepository(image.RepoTags)
+		if repo == "" || time.Unix(image.Created, 0).After(cutoff) {
+			continue
+		}
+		for _, tag := range image.RepoTags {
+			if !strings.HasPrefix(tag, repo+":") {
+				continue
+			}
+			if !opts.DryRun {
+				err := e.Docker.Client.RemoveImage(tag)
+				if err != nil {
+					log.Errorf("Error - Cannot remove image %s: %s", tag, err)
+					failed++
+					continue
+				}
+			}
+			log.Infof("%s image %s", action, tag)
+			removed++
+		}
+	}
+
+	// Networks of runs without any container left, a run
+	// creates its network before attaching its containers
+	networks, err := e.Docker.Client.ListNetworks()
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if !strings.HasPrefix(network.Name, "smg-") {
+			continue
+		}
+		n, err := e.Docker.Client.NetworkInfo(network.ID)
+		if err != nil || len(n.Containers) > 0 || networkCreated(n).After(cutoff) {
+			continue
+		}
+		if !opts.DryRun {
+			err := e.Docker.Client.RemoveNetwork(network.ID)
+			if err != nil {
+				log.Errorf("Error - Cannot remove network %s: %s", network.Name, err)
+				failed++
+				continue
+			}
+		}
+		log.Infof("%s network %s", action, network.Name)
+		removed++
+	}
+
+	// Temporary build contexts
+	dirs, err := filepath.Glob(BUILD_TMP + "*")
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if !opts.DryRun {
+			err := os.RemoveAll(dir)
+			if err != nil {
+				log.Errorf("Error - Cannot remove directory %s: %s", dir, err)
+				failed++
+				continue
+			}
+		}
+		log.Infof("%s directory %s", action, dir)
+		removed++
+	}
+
+	log.Infof("--> %s %d leftovers", action, removed)
+	if failed > 0 {
+		return fmt.Errorf("%d leftovers could not be removed", failed)
+	}
+	return nil
+}
+
+// isRunContainer tells if the container was created by a run,
+// only the labels of smg are trusted, names can be anyone's
+func isRunContainer(c dockerclient.APIContainers) bool {
+	if _, ok := c.Labels[LABEL_APP]; !ok {
+		return false
+	}
+	role := c.Labels[LABEL_ROLE]
+	return role == ROLE_CONTROLLER || role == ROLE_SERVICE
+}
+
+// runImageRepository returns the repository of the image
+// if it has been built for a run
+func runImageRepository(tags []string) string {
+	for _, tag := range tags {
+		i := strings.LastIndex(tag, ":")
+		if i > 0 && tag[i+1:] == RUN_TAG {
+			return tag[:i]
+		}
+	}
+	return ""
+}
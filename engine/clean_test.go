@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+func TestIsRunContainer(t *testing.T) {
+	// Names alone are not enough, foo-bar-31234 may be anyone's
+	for _, name := range []string{"/cassandra-smuggler-31234", "/foo-bar-31234", "/mongo"} {
+		c := dockerclient.APIContainers{Names: []string{name}}
+		if isRunContainer(c) {
+			t.Errorf("Container %s without labels belongs to a run", name)
+		}
+	}
+
+	c := dockerclient.APIContainers{
+		Names:  []string{"/cassandra-smuggler"},
+		Labels: map[string]string{LABEL_APP: "smuggler", LABEL_ROLE: ROLE_SERVICE},
+	}
+	if !isRunContainer(c) {
+		t.Errorf("Labelled containers belong to runs")
+	}
+
+	c.Labels[LABEL_ROLE] = ROLE_BUILD
+	if isRunContainer(c) {
+		t.Errorf("Build containers don't belong to runs")
+	}
+}
+
+func TestNetworkCreated(t *testing.T) {
+	n := &dockerclient.Network{Options: map[string]string{LABEL_CREATED: "1476691200"}}
+	if created := networkCreated(n); !created.Equal(time.Unix(1476691200, 0)) {
+		t.Errorf("Expected 1476691200, got %d", created.Unix())
+	}
+	if created := networkCreated(&dockerclient.Network{}); !created.IsZero() {
+		t.Errorf("Expected the zero time, got %s", created)
+	}
+}
+
+func TestRunImageRepository(t *testing.T) {
+	repo := runImageRepository([]string{"debian-smuggler-31234:latest", "debian-smuggler-31234:stmp"})
+	if repo != "debian-smuggler-31234" {
+		t.Errorf("Expected debian-smuggler-31234, got %s", repo)
+	}
+	repo = runImageRepository([]string{"registry:5000/smuggler:stmp"})
+	if repo != "registry:5000/smuggler" {
+		t.Errorf("Expected registry:5000/smuggler, got %s", repo)
+	}
+	if repo := runImageRepository([]string{"debian:jessie"}); repo != "" {
+		t.Errorf("Expected no repository, got %s", repo)
+	}
+}
@@ -25,12 +25,38 @@ type Container struct {
 	Client           *dockerclient.Client
 	HostConfig       *dockerclient.HostConfig
 	ContainerConfig  *dockerclient.Config
+	NetworkingConfig *dockerclient.NetworkingConfig
 	Docker           *dockerclient.Container
 	Ports            []dockerclient.Port
 	WorkingDirectory string
 	Links            []*Container
+	Limits           SystemLimits
+	// StrictPorts binds host ports as declared
+	// instead of looking for a free one
+	StrictPorts bool
+	HealthCheck *HealthCheck
+	healthy     bool
+	// Output receives the logs of the
+	// container when started with out
+	Output io.Writer
+	// Timeout of the container when
+	// started with out, zero waits forever
+	Timeout time.Duration
+	// StopTimeout is the time given to the
+	// container to stop before being killed
+	StopTimeout time.Duration
+	// Network is the name of the network
+	// of the run the container is attached to
+	Network string
 }
 
+// STOP_TIMEOUT is the default stop timeout of containers
+const STOP_TIMEOUT = 10 * time.Second
+
+// LOGS_TIMEOUT is how long the end of the logs
+// is waited for once the container exited
+const LOGS_TIMEOUT = 5 * time.Second
+
 // Inspect get the container definition
 // and auto-protect the container against
 // deletion, to avoid killing or removing
@@ -88,12 +114,24 @@ func (c *Container) Start(out bool) (int, error) {
 		}
 
 		if out {
-			c.Logs(c.Docker.ID, utils.StdPre, utils.StdPre)
-			c.Code, err = c.Client.WaitContainer(c.Docker.ID)
+			var output io.Writer = utils.StdPre
+			if c.Output != nil {
+				output = c.Output
+			}
+			done := c.Logs(c.Docker.ID, output, output)
+			c.Code, err = c.Wait(c.Timeout)
+			if _, ok := err.(*TimeoutError); ok {
+				return -1, err
+			}
 			if err != nil {
 				return -1, nil
 			}
 
+			// Let the end of the logs go through
+			select {
+			case <-done:
+			case <-time.After(LOGS_TIMEOUT):
+			}
 		}
 	}
 
@@ -101,9 +139,12 @@ func (c *Container) Start(out bool) (int, error) {
 }
 
 // Logs is calling dockerclient logs function
-// into a goroutine so we're not blocker by it
-func (c *Container) Logs(id string, out, err io.Writer) {
+// into a goroutine so we're not blocker by it,
+// the channel is closed when the logs end
+func (c *Container) Logs(id string, out, err io.Writer) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 
 		c.Client.Logs(dockerclient.LogsOptions{
 			Container:    id,
@@ -113,8 +154,38 @@ func (c *Container) Logs(id string, out, err io.Writer) {
 			Stdout:       true,
 			Stderr:       true,
 		})
-
+		utils.Flush(out)
+		utils.Flush(err)
 	}()
+	return done
+}
+
+// Exec runs the command inside the running container
+// and returns its exit code
+func (c *Container) Exec(cmd []string, out, err io.Writer) (int, error) {
+	exec, e := c.Client.CreateExec(dockerclient.CreateExecOptions{
+		Container:    c.Docker.ID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if e != nil {
+		return -1, e
+	}
+
+	e = c.Client.StartExec(exec.ID, dockerclient.StartExecOptions{
+		OutputStream: out,
+		ErrorStream:  err,
+	})
+	if e != nil {
+		return -1, e
+	}
+
+	inspect, e := c.Client.InspectExec(exec.ID)
+	if e != nil {
+		return -1, e
+	}
+	return inspect.ExitCode, nil
 }
 
 // SetProtection is taking protective mesure to
@@ -123,22 +194,39 @@ func (c *Container) SetProtection(p bool) {
 	c.Protection = p
 }
 
-// Stop is stopping the container
+// Stop stops the container gracefully, it is
+// killed if still running after its stop timeout
 func (c *Container) Stop() error {
 	if c.Protection {
 		return nil
 	}
 	if c.IsRunning(c.Docker.ID) {
-		opts := dockerclient.KillContainerOptions{ID: c.Docker.ID}
-		err := c.Client.KillContainer(opts)
-		log.Debugf("Killing %s", c.Image.Name)
+		timeout := c.StopTimeout
+		if timeout <= 0 {
+			timeout = STOP_TIMEOUT
+		}
+		log.Debugf("Stopping %s", c.Image.Name)
+		err := c.Client.StopContainer(c.Docker.ID, uint(timeout.Seconds()))
 		if err != nil {
-			log.Debugf("ERROR killing %s, %s", c.Image, err)
+			log.Debugf("ERROR stopping %s, %s", c.Image, err)
 		}
 	}
 	return nil
 }
 
+// Kill removes the container without waiting for it to stop
+func (c *Container) Kill() error {
+	if c.Protection || c.Docker == nil {
+		return nil
+	}
+	log.Debugf("Killing %s", c.Image.Name)
+	return c.Client.RemoveContainer(dockerclient.RemoveContainerOptions{
+		ID:            c.Docker.ID,
+		Force:         true,
+		RemoveVolumes: true,
+	})
+}
+
 // Delete is deleting the container
 func (c *Container) Delete(force bool) error {
 	// Keepalive means protection against suppression
@@ -162,21 +250,41 @@ func (c *Container) Delete(force bool) error {
 
 // If system limits are defined in the smuggler conf file
 // we're setting them to the container
-func (c *Container) SetSystemLimits(cpu int64, ram int64) error {
-	c.ContainerConfig.CPUShares = cpu
-	c.ContainerConfig.Memory = ram
+func (c *Container) SetSystemLimits(limits SystemLimits) error {
+	if c.HostConfig == nil {
+		c.HostConfig = &dockerclient.HostConfig{}
+	}
+
+	c.Limits = limits
+
+	// Older daemons read limits from the config
+	c.ContainerConfig.CPUShares = limits.CPUShares
+	c.ContainerConfig.Memory = limits.Memory
+	c.ContainerConfig.MemorySwap = limits.MemorySwap
+
+	c.HostConfig.CPUShares = limits.CPUShares
+	c.HostConfig.Memory = limits.Memory
+	c.HostConfig.MemorySwap = limits.MemorySwap
+	c.HostConfig.PidsLimit = limits.PidsLimit
+
 	return nil
 }
 
 func (c *Container) CreateDockerContainer() (err error) {
 
 	opts := dockerclient.CreateContainerOptions{
-		Name:   c.Name,
-		Config: c.ContainerConfig,
+		Name:             c.Name,
+		Config:           c.ContainerConfig,
+		HostConfig:       c.HostConfig,
+		NetworkingConfig: c.NetworkingConfig,
 	}
 
 	c.Docker, err = c.Client.CreateContainer(opts)
 	if err != nil {
+		if c.Limits != (SystemLimits{}) {
+			return fmt.Errorf("%s rejected system limits (cpu %d, ram %d, swap %d, pids %d): %s",
+				c.Name, c.Limits.CPUShares, c.Limits.Memory, c.Limits.MemorySwap, c.Limits.PidsLimit, err)
+		}
 		return fmt.Errorf("%s %s", c.Image, err)
 	}
 	return nil
@@ -205,6 +313,27 @@ func (c *Container) SetContainerConfig(env []string, cmd []string) error {
 	return nil
 }
 
+// SetInteractive replaces the command by an interactive
+// one, with a tty and stdin attached
+func (c *Container) SetInteractive(cmd []string) {
+	c.ContainerConfig.Cmd = cmd
+	c.ContainerConfig.Entrypoint = []string{""}
+	c.ContainerConfig.Tty = true
+	c.ContainerConfig.OpenStdin = true
+	c.ContainerConfig.StdinOnce = true
+}
+
+// SetEntrypoint overrides the entrypoint and cmd
+// of the image, empty values keep the image ones
+func (c *Container) SetEntrypoint(entrypoint []string, cmd []string) {
+	if len(entrypoint) > 0 {
+		c.ContainerConfig.Entrypoint = entrypoint
+	}
+	if len(cmd) > 0 {
+		c.ContainerConfig.Cmd = cmd
+	}
+}
+
 func (c *Container) SetBinds(binds []string) error {
 
 	if c.HostConfig == nil {
@@ -259,7 +388,7 @@ func (c *Container) SetPorts(ports []string) error {
 			// Set the test against the random port, set a generate
 			// function to obtain a free port
 			if len(ps) == 2 {
-				if utils.PortUsable(c.ParsePort(ps[0])) {
+				if c.StrictPorts || utils.PortUsable(c.ParsePort(ps[0])) {
 					hostPort = ps[0]
 				} else {
 					hostPort = strconv.Itoa(rand.Intn(10000) + 30000)
@@ -313,16 +442,3 @@ func (c *Container) ContainerPort(p string) dockerclient.Port {
 	// No protocol, asign tcp
 	return dockerclient.Port(p + "/tcp")
 }
-
-func (c *Container) addLinks(links []*Container) {
-
-	var linked []string
-
-	if len(links) > 0 {
-		for _, l := range links {
-			linked = append(linked, l.Name+":"+l.Hostname)
-			log.Infof("Linking %s", l.Hostname)
-		}
-		c.HostConfig.Links = linked
-	}
-}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Deploy target types
+const (
+	DEPLOY_DOCKER = "docker"
+	DEPLOY_HOOK   = "hook"
+)
+
+// DeployTarget is a named deploy declared in the
+// deploy section, and subscribed by builds
+type DeployTarget struct {
+	Type string `yaml:"type"`
+
+	// Docker target, run the image on a docker host
+	// and replace the previous container
+	Host    string   `yaml:"host"`
+	Cert    string   `yaml:"cert"`
+	Key     string   `yaml:"key"`
+	CA      string   `yaml:"ca"`
+	Name    string   `yaml:"name"`
+	Ports   []string `yaml:"ports"`
+	Env     []string `yaml:"env"`
+	Volumes []string `yaml:"volumes"`
+	Pull    bool     `yaml:"pull"`
+
+	// Hook target, run a shell command with
+	// the built image in env variables
+	Command string `yaml:"command"`
+}
+
+// Deploy runs each deploy target subscribed by the active
+// build, tag is the image tag to deploy
+func (e *Engine) Deploy(image ImageName, tag string) error {
+
+	targets := e.App.GetSubscriptions()
+	if len(targets) == 0 {
+		log.Infof("--> No deploy for this build")
+		return nil
+	}
+
+	if tag == "" {
+		tag = deployTag(e.App, image)
+	}
+
+	for _, name := range targets {
+		target, ok := e.App.Deploys[name]
+		if !ok || target == nil {
+			return fmt.Errorf("Deploy %s not found in %s", name, e.App.FilePath)
+		}
+
+		log.Infof("--> Deploying %s:%s to %s", image.Name, tag, name)
+
+		var err error
+		switch target.Type {
+		case DEPLOY_DOCKER:
+			err = e.deployDocker(name, target, image, tag)
+		case DEPLOY_HOOK:
+			err = e.deployHook(name, target, image, tag)
+		default:
+			err = fmt.Errorf("Unknown type %s", target.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("Deploy %s failed: %s", name, err)
+		}
+		log.Infof("--> Deploy %s succeed", name)
+	}
+
+	return nil
+}
+
+// deployTag returns the tag of the image deployed by default, the
+// short commit unless the tag templates of the build leave it out
+func deployTag(a *Application, image ImageName) string {
+	if a.Git != nil && a.Git.LastCommit.Short != "" {
+		for _, t := range image.Tags {
+			if t == a.Git.LastCommit.Short {
+				return t
+			}
+		}
+	}
+	if len(image.Tags) > 0 {
+		return image.Tags[0]
+	}
+	return "latest"
+}
+
+// DeployTag deploys an already built image, the tag is used
+// both to find the build and as the image tag, like for builds
+func (e *Engine) DeployTag(tag string) error {
+
+	if len(e.App.Builds) == 0 {
+		return exitError(EXIT_CONFIG, fmt.Errorf("No build definition matches this branch in your smuggler file"))
+	}
+
+	_, err := e.App.InitBuild(tag)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+
+	err = CheckBuildTags(e.App, tag)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+
+	err = e.Docker.Connect()
+	if err != nil {
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to Docker host at %s", err))
+	}
+
+	err = e.Docker.Configure(e.App, BUILD)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+
+	// Builds with several images deploy the first one
+	image := GetNameFromAppWithTag(e.App, tag, BUILD)
+	if images := GetImagesFromApp(e.App, tag); len(images) > 0 {
+		image = images[0]
+	}
+
+	return exitError(EXIT_DEPLOY, e.Deploy(image, tag))
+}
+
+func (e *Engine) deployDocker(name string, t *DeployTarget, image ImageName, tag string) error {
+
+	d := e.Docker
+	if t.Host != "" {
+		d = &Docker{
+			Host: t.Host,
+			Cert: t.Cert,
+			Key:  t.Key,
+			CA:   t.CA,
+		}
+		err := d.Connect()
+		if err != nil {
+			return fmt.Errorf("Could not connect to Docker host %s: %s", t.Host, err)
+		}
+	}
+
+	ref := image.Name + ":" + tag
+
+	// Pull the image if the host doesn't have it
+	err := d.Builder.IssetImage(ref, true, t.Pull)
+	if err != nil {
+		return err
+	}
+
+	containerName := t.Name
+	if containerName == "" {
+		containerName = e.App.getHostname(image.Name)
+	}
+
+	container := &Container{
+		Client:      d.Client,
+		Name:        containerName,
+		Image:       ImageName{Name: image.Name, Tags: []string{tag}},
+		Hostname:    containerName,
+		StrictPorts: true,
+	}
+
+	// Replace the previous container
+	if container.Exists(containerName) {
+		log.Infof("--> Replacing previous container %s", containerName)
+		container.SetProtection(false)
+		err := container.Stop()
+		if err != nil {
+			return fmt.Errorf("Can't kill existing container: %s", err)
+		}
+		err = container.Delete(true)
+		if err != nil {
+			return fmt.Errorf("Can't delete existing container: %s", err)
+		}
+		container.Name = containerName
+	}
+
+	err = container.SetContainerConfig(t.Env, nil)
+	if err != nil {
+		return err
+	}
+	container.SetLabels(e.App.Labels(ROLE_DEPLOY))
+	container.SetLabels(map[string]string{LABEL_DEPLOY: name})
+
+	err = container.SetPorts(t.Ports)
+	if err != nil {
+		return err
+	}
+
+	if len(t.Volumes) > 0 {
+		err = container.SetBinds(t.Volumes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = container.CreateDockerContainer()
+	if err != nil {
+		return err
+	}
+
+	_, err = container.Start(false)
+	return err
+}
+
+func (e *Engine) deployHook(name string, t *DeployTarget, image ImageName, tag string) error {
+
+	if t.Command == "" {
+		return fmt.Errorf("No command for hook")
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", t.Command)
+	cmd.Dir = e.App.WorkingDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	cmd.Env = append(os.Environ(), t.Env...)
+	cmd.Env = append(cmd.Env,
+		"SMG_DEPLOY="+name,
+		"SMG_IMAGE="+image.Name+":"+tag,
+		"SMG_IMAGE_NAME="+image.Name,
+		"SMG_IMAGE_TAG="+tag,
+		"SMG_IMAGE_TAGS="+strings.Join(image.Tags, " "),
+		"SMG_IMAGES="+strings.Join(image.GetAllNames(), " "),
+	)
+	if e.App.Git != nil {
+		cmd.Env = append(cmd.Env,
+			"SMG_GIT_BRANCH="+e.App.Git.Branch,
+			"SMG_GIT_COMMIT="+e.App.Git.LastCommit.ID,
+		)
+	}
+
+	return cmd.Run()
+}
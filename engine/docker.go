@@ -4,11 +4,14 @@ package engine
 
 import (
 	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
 )
 
 type Docker struct {
@@ -22,6 +25,23 @@ type Docker struct {
 	App        *Application
 	Controller *Container
 	Services   []*Container
+	Network    *dockerclient.Network
+	// Shell replaces the run of the controller
+	// by an interactive command
+	Shell []string
+	// Report of the commands of the run
+	Report *Report
+	// Output receives the logs of the controller,
+	// prefixed output by default
+	Output io.Writer
+	// Built are the images of the build,
+	// written in the build manifest
+	Built []*ImageManifest
+
+	// lock guards the network and the containers of the
+	// run, stopped by a signal while the run sets them up
+	lock        sync.Mutex
+	interrupted bool
 }
 
 // Usage modes
@@ -63,7 +83,12 @@ func (d *Docker) InitBuilder() {
 
 // Build docker image, and according the push flag, push image on repository.
 // If not empty, append the given tag to the image
-func (d *Docker) Build(push bool, cleanup bool, tag string) (ImageName, error) {
+func (d *Docker) Build(push bool, tag string) (ImageName, error) {
+
+	// Builds with several images build them all before pushing
+	if d.App.ActiveBuild != nil && len(d.App.ActiveBuild.Images) > 0 {
+		return d.BuildImages(push, tag)
+	}
 
 	// Get the name for the image
 	image := GetNameFromAppWithTag(d.App, tag, BUILD)
@@ -71,32 +96,43 @@ func (d *Docker) Build(push bool, cleanup bool, tag string) (ImageName, error) {
 	// Let's build the image
 	err := d.BuildDockerfile(image)
 	if err != nil {
-		return ImageName{}, err
+		return ImageName{}, exitError(EXIT_BUILD, err)
 	}
 
 	if push {
-		err := d.Builder.PushImage(image)
+		digest, err := d.Builder.PushImage(image)
 		if err != nil {
-			return ImageName{}, err
+			return ImageName{}, exitError(EXIT_BUILD, err)
 		}
+		d.pushed(image, digest)
 	}
 
-	if cleanup {
-		err := d.RemoveImage(image)
-		if err != nil {
-			return ImageName{}, err
+	return image, nil
+}
+
+// buildLabels returns the labels of the built images, the
+// labels of smg can't be overridden by the build definition
+func (d *Docker) buildLabels() map[string]string {
+	labels := d.App.ImageLabels(ROLE_BUILD)
+	if d.App.ActiveBuild != nil {
+		for k, v := range d.App.ActiveBuild.Labels {
+			if _, ok := labels[k]; !ok {
+				labels[k] = utils.EnvResolver(v)
+			}
 		}
 	}
-
-	return image, nil
+	return labels
 }
 
 func (d *Docker) BuildDockerfile(name ImageName) error {
 	log.Infof("--> Building image %s", name.ToString())
+	d.Builder.Labels = d.buildLabels()
+
 	err := d.Builder.MakeImage(name.Dockerfile, name, true, true)
 	if err != nil {
 		return err
 	}
+	d.built(name)
 	return nil
 
 }
@@ -124,12 +160,12 @@ func (d *Docker) BuildImage(app *Application, name ImageName, env string) error
 	// Setup the run.sh script to run smuggler style
 	if env != "" {
 		if _, ok := app.Commands[env]; !ok {
-			return fmt.Errorf("Environment %s not found.", env)
+			return exitError(EXIT_CONFIG, fmt.Errorf("Environment %s not found.", env))
 		}
 
-		err := d.Builder.WriteRunScript("run.sh", app.Commands[env], false)
+		err := d.Builder.WriteRunScript("run.sh", app.Commands[env], false, app.Report != "")
 		if err != nil {
-			return err
+			return exitError(EXIT_BUILD, err)
 		}
 		d.Builder.Copy(". /data/")
 		d.Builder.AddCmd("/data/run.sh")
@@ -154,13 +190,17 @@ func (d *Docker) BuildImage(app *Application, name ImageName, env string) error
 	// And write the Dockerfile
 	err = d.Builder.InitDockerfile("Dockerfile")
 	if err != nil {
-		log.Fatalf("%s", err)
+		return exitError(EXIT_BUILD, err)
 	}
 	// Make the image and sent it to the api
+	role := ROLE_SERVICE
+	if app == d.App {
+		role = ROLE_CONTROLLER
+	}
+	d.Builder.Labels = d.App.ImageLabels(role)
 	err = d.Builder.MakeImage("Dockerfile", name, false, app.NoCache)
 	if err != nil {
-		log.Fatalf("%s", err)
-		return err
+		return exitError(EXIT_BUILD, err)
 	}
 
 	// TODO : take that away too
@@ -195,9 +235,10 @@ func (d *Docker) SetupBaseImage(app *Application) error {
 	// Build the image
 	if err != nil {
 		log.Infof("Building Image %s", name.ToString())
+		tmpBuilder.Labels = d.App.ImageLabels(ROLE_BASE)
 		err = tmpBuilder.MakeImage(file, name, true, d.App.NoCache)
 		if err != nil {
-			return fmt.Errorf("%s", err)
+			return exitError(EXIT_BUILD, err)
 		}
 
 	}
@@ -218,8 +259,25 @@ func (d *Docker) SetupContainers() error {
 		}
 	}
 
+	// Every run gets its own network
+	if _, err := d.runNetwork(); err != nil {
+		return err
+	}
+	err := d.CreateNetwork()
+	if err != nil {
+		return err
+	}
+
 	if len(d.App.Applications) > 0 {
-		for _, service := range d.App.Applications {
+		// Services are created in dependency order
+		order, err := d.App.SortApplications()
+		if err != nil {
+			return exitError(EXIT_CONFIG, err)
+		}
+		containers := make(map[string]*Container)
+
+		for _, n := range order {
+			service := d.App.Applications[n]
 			if service.ImageFile != "" {
 				err := d.SetupBaseImage(service)
 				if err != nil {
@@ -244,15 +302,31 @@ func (d *Docker) SetupContainers() error {
 			name := GetNameFromApp(service, RUN)
 
 			if service.UseDockerfile {
-				d.BuildImage(service, name, d.App.Environment)
+				err := d.BuildImage(service, name, d.App.Environment)
+				if err != nil {
+					return err
+				}
 			}
 
 			container := &Container{
-				Client:   d.Client,
-				Name:     service.Name,
-				Image:    name,
-				Hostname: service.Hostname,
-				Tags:     name.Tags,
+				Client:      d.Client,
+				Name:        service.Name,
+				Image:       name,
+				Hostname:    service.Hostname,
+				Tags:        name.Tags,
+				HealthCheck: service.HealthCheck,
+			}
+
+			// Services are started after the ones they depend on
+			for _, dep := range service.DependsOn {
+				container.Links = append(container.Links, containers[dep])
+			}
+			containers[n] = container
+
+			// Nothing is created once the run is interrupted
+			network, err := d.runNetwork()
+			if err != nil {
+				return err
 			}
 
 			if !container.Exists(service.ID) && !container.Exists(service.Name) {
@@ -264,8 +338,10 @@ func (d *Docker) SetupContainers() error {
 
 				err := container.SetContainerConfig(env, nil)
 				if err != nil {
-					return nil
+					return err
 				}
+				container.SetLabels(d.App.Labels(ROLE_SERVICE))
+				container.SetLabels(map[string]string{LABEL_HOST: container.Hostname})
 
 				err = container.SetPorts(service.Ports)
 				if err != nil {
@@ -279,6 +355,17 @@ func (d *Docker) SetupContainers() error {
 					}
 				}
 
+				limits, err := d.App.GetSystemLimits(service.Hostname)
+				if err != nil {
+					return exitError(EXIT_CONFIG, err)
+				}
+				err = container.SetSystemLimits(limits)
+				if err != nil {
+					return err
+				}
+
+				container.SetNetwork(network.Name)
+
 				err = container.CreateDockerContainer()
 				if err != nil {
 					return err
@@ -291,29 +378,47 @@ func (d *Docker) SetupContainers() error {
 				if !container.IsRunning(service.ID) && !container.IsRunning(service.Name) {
 					code, err := container.Start(false)
 					if err != nil {
-						log.Fatalf("Can't start container %s, exit with code %d", service.Name, code)
+						return exitError(EXIT_SERVICE, fmt.Errorf("Can't start container %s, exit with code %d", service.Name, code))
 					}
 					log.Infof("Successfully restarted Service %s", container.Name)
 				}
 
+				err := container.ConnectNetwork(network)
+				if err != nil {
+					return err
+				}
+
 				log.Infof("Successfully attached Service %s with %s", container.Hostname, container.Name)
 			}
+			d.lock.Lock()
 			d.Services = append(d.Services, container)
+			d.lock.Unlock()
 
 		}
 	}
 
 	image := GetNameFromApp(d.App, RUN)
-
-	// If we use Dockerfile that mean we need to build an image
-	// from the actual directory
-	if d.App.UseDockerfile {
+	cmd := d.App.Commands[d.App.Environment]
+
+	// Dockerfile environments are built from the user Dockerfile,
+	// otherwise if we use Dockerfile that mean we need to build
+	// an image from the actual directory
+	dockerfileEnv := d.App.GetDockerfileEnv(d.App.Environment)
+	if dockerfileEnv != nil {
+		cmd = nil
+		log.Infof("--> Building image %s from %s", image.ToString(), dockerfileEnv.Dockerfile)
+		d.Builder.Labels = d.App.ImageLabels(ROLE_CONTROLLER)
+		err := d.Builder.MakeImage(dockerfileEnv.Dockerfile, image, d.App.Uptodate, d.App.NoCache)
+		if err != nil {
+			return exitError(EXIT_BUILD, err)
+		}
+	} else if d.App.UseDockerfile {
 		err := d.BuildImage(d.App, image, d.App.Environment)
 		if err != nil {
 			return err
 		}
 	} else {
-		d.Builder.WriteRunScript("run.sh", d.App.Commands[d.App.Environment], true)
+		d.Builder.WriteRunScript("run.sh", d.App.Commands[d.App.Environment], true, d.App.Report != "")
 	}
 
 	// Setup the base container with the image name
@@ -349,9 +454,19 @@ func (d *Docker) SetupContainers() error {
 	}
 	// Let's set or reset container config
 	// with the right parameters
-	err := container.SetContainerConfig(d.App.Env, d.App.Commands[d.App.Environment])
+	err = container.SetContainerConfig(d.App.Env, cmd)
 	if err != nil {
-		return nil
+		return err
+	}
+	container.SetLabels(d.App.Labels(ROLE_CONTROLLER))
+	container.SetLabels(map[string]string{LABEL_HOST: container.Hostname})
+
+	if dockerfileEnv != nil {
+		container.SetEntrypoint(dockerfileEnv.Entrypoint, dockerfileEnv.Cmd)
+	}
+
+	if len(d.Shell) > 0 {
+		container.SetInteractive(d.Shell)
 	}
 
 	err = container.SetPorts(d.App.Ports)
@@ -377,20 +492,33 @@ func (d *Docker) SetupContainers() error {
 		}
 	}
 
-	// Create the containers, just have to do links
-	// and start the container
-	err = container.CreateDockerContainer()
+	limits, err := d.App.GetSystemLimits(d.App.AppName)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+	err = container.SetSystemLimits(limits)
 	if err != nil {
 		return err
 	}
 
-	// Links
-	if len(d.Services) > 0 {
-		container.addLinks(d.Services)
+	// Services are reached through the network
+	// with their hostname
+	network, err := d.runNetwork()
+	if err != nil {
+		return err
+	}
+	container.SetNetwork(network.Name)
+
+	// Create the containers, just have to start it
+	err = container.CreateDockerContainer()
+	if err != nil {
+		return err
 	}
 
 	// Container is set correctly
+	d.lock.Lock()
 	d.Controller = container
+	d.lock.Unlock()
 
 	return nil
 }
@@ -412,16 +540,51 @@ func (d *Docker) Configure(app *Application, mode int) error {
 
 }
 
+// Interrupt stops the setup of the run, no container
+// is created afterwards. The running ones are stopped
+// if stop is true
+func (d *Docker) Interrupt(stop bool) {
+	d.lock.Lock()
+	d.interrupted = true
+	d.lock.Unlock()
+
+	if stop {
+		d.Stop()
+	}
+}
+
+// runNetwork returns the network of the run, or an
+// error once the run is interrupted
+func (d *Docker) runNetwork() (*dockerclient.Network, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.interrupted {
+		return nil, &ExitError{Code: EXIT_INTERRUPTED, Err: fmt.Errorf("Run interrupted")}
+	}
+	return d.Network, nil
+}
+
+// containers returns the controller and services of
+// the run, they can be read while the run sets them up
+func (d *Docker) containers() (*Container, []*Container) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Controller, append([]*Container{}, d.Services...)
+}
+
 func (d *Docker) Stop() error {
 
-	if d.Controller != nil {
-		err := d.Controller.Stop()
+	controller, services := d.containers()
+	if controller != nil {
+		controller.StopTimeout = d.App.StopTimeout
+		err := controller.Stop()
 		if err != nil {
-			log.Errorf("Error - Cannot stop %s", d.Controller.ID)
+			log.Errorf("Error - Cannot stop %s", controller.ID)
 		}
 	}
 
-	for _, service := range d.Services {
+	for _, service := range services {
+		service.StopTimeout = d.App.StopTimeout
 		err := service.Stop()
 		if err != nil {
 			log.Errorf("Error - Cannot stop %s", service.ID)
@@ -431,9 +594,35 @@ func (d *Docker) Stop() error {
 	return nil
 }
 
+// Kill removes the containers of the run without
+// waiting for them to stop, then the network
+func (d *Docker) Kill() {
+	controller, containers := d.containers()
+	if controller != nil {
+		containers = append([]*Container{controller}, containers...)
+	}
+	for _, c := range containers {
+		err := c.Kill()
+		if err != nil {
+			log.Debugf("ERROR killing %s, %s", c.Hostname, err)
+		}
+	}
+
+	if controller != nil && d.App.UseDockerfile {
+		d.RemoveImage(controller.Image)
+	}
+
+	err := d.RemoveNetwork()
+	if err != nil {
+		log.Errorf("%s", err)
+	}
+}
+
 func (d *Docker) Delete() error {
+	controller, services := d.containers()
+
 	// Delete each services
-	for _, service := range d.Services {
+	for _, service := range services {
 		err := service.Delete(false)
 		if err != nil {
 			log.Errorf("Error - Cannot delete %s", service.ID)
@@ -441,18 +630,18 @@ func (d *Docker) Delete() error {
 	}
 
 	// Delete the container
-	if d.Controller != nil {
+	if controller != nil {
 
-		err := d.Controller.Delete(false)
+		err := controller.Delete(false)
 		if err != nil {
-			log.Errorf("Error - Cannot delete %s", d.Controller.ID)
+			log.Errorf("Error - Cannot delete %s", controller.ID)
 		}
 
 		// Do not remove the image if we've got
 		// binded volume since the image is
 		// not a custom built
 		if d.App.UseDockerfile {
-			err = d.RemoveImage(d.Controller.Image)
+			err = d.RemoveImage(controller.Image)
 			if err != nil {
 				log.Errorf("%s", err)
 			}
@@ -464,33 +653,107 @@ func (d *Docker) Delete() error {
 
 func (d *Docker) Start() error {
 
-	for _, service := range d.Services {
-		_, err := service.Start(false)
-		if err != nil {
-			return err
-		}
+	err := d.StartServices()
+	if err != nil {
+		return err
 	}
+
+	var out io.Writer = utils.StdPre
+	if d.Output != nil {
+		out = d.Output
+	}
+	d.Controller.Output = out
+
+	// Commands are reported one by one
+	if d.App.Report != "" {
+		d.Report = NewReport(d.App)
+		d.Controller.Output = d.Report.Writer(out)
+	}
+
 	// And we're ready to run
 	log.Infof("--> Running %s ...", d.Controller.Image.ToString())
 
+	d.Controller.Timeout = d.App.GetTimeout()
 	code, err := d.Controller.Start(true)
+	if _, ok := err.(*TimeoutError); ok {
+		log.Errorf("--> %s", err)
+		d.Timeout()
+		if d.Report != nil {
+			d.Report.Finish(EXIT_TIMEOUT)
+		}
+		utils.Flush(out)
+		return err
+	}
 	if err != nil {
 		return err
 	}
+	if d.Report != nil {
+		d.Report.Finish(code)
+	}
+	utils.Flush(out)
 	log.Infof("--> Run Exited with code %d", code)
 	if code != 0 {
-		return fmt.Errorf("Run failed %d", code)
+		return &RunError{Code: code}
 	}
 	log.Infof("--> Success !")
 
 	return nil
 }
 
+// StartServices starts the services in dependency
+// order and waits for them to be ready
+func (d *Docker) StartServices() error {
+
+	host := d.HostIP()
+	for _, service := range d.Services {
+		// Dependencies must be ready before starting
+		for _, dep := range service.Links {
+			err := dep.WaitHealthy(host)
+			if err != nil {
+				return exitError(EXIT_SERVICE, err)
+			}
+		}
+		_, err := service.Start(false)
+		if err != nil {
+			return exitError(EXIT_SERVICE, err)
+		}
+	}
+
+	// Wait for the services to be ready
+	return exitError(EXIT_SERVICE, d.WaitServices())
+}
+
+// WaitServices runs the healthchecks of every services
+// and fails with the first unhealthy one
+func (d *Docker) WaitServices() error {
+	errs := make(chan error, len(d.Services))
+	host := d.HostIP()
+
+	for _, service := range d.Services {
+		go func(c *Container) {
+			errs <- c.WaitHealthy(host)
+		}(service)
+	}
+
+	var err error
+	for range d.Services {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 // API call
 
-func (d *Docker) ListContainers(running bool) ([]dockerclient.APIContainers, error) {
+// ListContainers lists running containers, or all of them,
+// matching every given label filter (key or key=value)
+func (d *Docker) ListContainers(all bool, labels ...string) ([]dockerclient.APIContainers, error) {
 	options := dockerclient.ListContainersOptions{
-		All: true,
+		All: all,
+	}
+	if len(labels) > 0 {
+		options.Filters = map[string][]string{"label": labels}
 	}
 	containers, err := d.Client.ListContainers(options)
 	if err != nil {
@@ -506,6 +769,7 @@ func (d *Docker) RemoveImage(img ImageName) error {
 		err := d.Client.RemoveImage(img.Name + ":" + tag)
 		if err != nil {
 			log.Debugf("ERROR removing image %s, %s", img.Name, err)
+			continue
 		}
 		log.Infof("Remove image %s:%s", img.Name, tag)
 	}
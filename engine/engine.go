@@ -2,15 +2,34 @@ package engine
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+// VERSION of smuggler
+const VERSION = "0.5.2"
+
 type Engine struct {
 	Docker    *Docker
 	ClusterID string
 	Config    *Config
 	App       *Application
+	// Summary of the last run
+	Summary *RunSummary
+	// Summaries of the environments run by RunAll
+	Summaries []*RunSummary
+
+	// Stops are serialized, a run can be
+	// interrupted while being stopped
+	lock        sync.Mutex
+	interrupted bool
+	// Engines running the environments of RunAll
+	children []*Engine
+	// Onlyif is the summary of the tests
+	// run before the build
+	Onlyif *RunSummary
 }
 
 func New(c *Config) (*Engine, error) {
@@ -50,10 +69,32 @@ func (e *Engine) Init(app *Application) error {
 	return nil
 }
 
-func (e *Engine) Build(push bool, cleanup bool, tag string) error {
+func (e *Engine) Build(push bool, cleanup bool, tag string, env string) error {
+	started := time.Now()
+	err := e.build(push, cleanup, tag, env)
+
+	// The manifest is written for failed builds
+	// too, with the images built so far
+	if e.App.Output != "" {
+		m := e.NewBuildManifest(env, tag, started, err)
+		if werr := m.WriteFile(e.App.Output); werr != nil {
+			log.Errorf("Could not write the build manifest %s: %s", e.App.Output, werr)
+		} else {
+			log.Infof("--> Build manifest written to %s", e.App.Output)
+		}
+	}
+	return err
+}
+
+func (e *Engine) build(push bool, cleanup bool, tag string, env string) error {
+
+	// Apply the environment overlay before looking for builds
+	if env != "" {
+		e.App.ApplyEnvironment(env)
+	}
 
 	if len(e.App.Builds) == 0 {
-		return fmt.Errorf("No build definition matches this branch in your smuggler file")
+		return exitError(EXIT_CONFIG, fmt.Errorf("No build definition matches this branch in your smuggler file"))
 	}
 
 	/*if !e.App.HasDockerfile() {
@@ -63,29 +104,51 @@ func (e *Engine) Build(push bool, cleanup bool, tag string) error {
 	// If multiple regexp matched, we're taking the first
 	env, err := e.App.InitBuild(tag)
 	if err != nil {
-		log.Printf("%s", err)
-		return nil
+		return exitError(EXIT_CONFIG, err)
+	}
+
+	opts, err := e.App.GetBuildOptions()
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+
+	err = CheckBuildTags(e.App, tag)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
 	}
 
 	if e.App.ActiveBuild.Onlyif != "" {
 		log.Infof("--> Running tests (%s) before building %s", e.App.ActiveBuild.Onlyif, env)
-		err := e.Run(e.App.ActiveBuild.Onlyif)
+
+		// The tests apply their own environment, they
+		// run on a copy of the application of the build
+		app, err := e.App.Clone()
+		if err != nil {
+			return exitError(EXIT_CONFIG, err)
+		}
+		tests, err := e.spawn(app, nil)
+		if err != nil {
+			return err
+		}
+		err = tests.Run(e.App.ActiveBuild.Onlyif)
+		e.Onlyif = tests.Summary
 		if err != nil {
 			log.Errorf("Build aborted...")
-			return fmt.Errorf("%s", err)
+			return err
 		}
 
 	}
 
 	err = e.Docker.Connect()
 	if err != nil {
-		return fmt.Errorf("Could not connect to Docker host at %s", err)
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to Docker host at %s", err))
 	}
 
 	err = e.Docker.Configure(e.App, 1)
 	if err != nil {
-		return err
+		return exitError(EXIT_CONFIG, err)
 	}
+	e.Docker.Builder.Options = opts
 
 	// If push is defined is the yaml
 	// no question asked we push
@@ -94,48 +157,189 @@ func (e *Engine) Build(push bool, cleanup bool, tag string) error {
 	}
 
 	// Build and push
-	_, err = e.Docker.Build(push, cleanup, tag)
+	image, err := e.Docker.Build(push, tag)
 	if err != nil {
 		return err
 	}
 
+	// Don't deploy an interrupted build
+	if e.Interrupted() {
+		return &ExitError{Code: EXIT_INTERRUPTED, Err: fmt.Errorf("Build interrupted")}
+	}
+
+	// Deploy the targets subscribed by the build
+	err = e.Deploy(image, tag)
+	if err != nil {
+		return exitError(EXIT_DEPLOY, err)
+	}
+
+	// The images are deleted once deployed,
+	// the deploy targets may run them
+	if cleanup {
+		for _, i := range e.Docker.Built {
+			e.Docker.RemoveImage(ImageName{Name: i.Name, Tags: i.Tags})
+		}
+	}
 	return nil
 }
 
+// Run runs the environment and prints its summary
 func (e *Engine) Run(env string) error {
+	err := e.runEnv(env)
+	e.Summary.Print()
+
+	if e.Docker.Report != nil {
+		e.writeReports([]*Report{e.Docker.Report})
+	}
+
+	return err
+}
+
+// runEnv runs the environment and sums it up
+func (e *Engine) runEnv(env string) error {
+	started := time.Now()
+	err := e.run(env)
+	if e.Interrupted() {
+		err = &ExitError{Code: EXIT_INTERRUPTED, Err: fmt.Errorf("Run interrupted")}
+	}
+
+	e.Summary = NewRunSummary(e.App, env, started, err)
+	if e.Docker.Report != nil {
+		e.Summary.SetReport(e.Docker.Report)
+	}
+
+	return err
+}
+
+// writeReports writes the reports of the run to the
+// report file, failures don't change the run result
+func (e *Engine) writeReports(reports []*Report) {
+	err := WriteReports(e.App.Report, reports)
+	if err != nil {
+		log.Errorf("Error - Cannot write report %s: %s", e.App.Report, err)
+		return
+	}
+	log.Infof("--> Report written to %s", e.App.Report)
+}
+
+func (e *Engine) run(env string) error {
+
+	if e.App.Report != "" {
+		_, err := ReportFormat(e.App.Report)
+		if err != nil {
+			return exitError(EXIT_CONFIG, err)
+		}
+	}
 
 	err := e.Docker.Connect()
 	if err != nil {
-		return fmt.Errorf("Could not connect to the Docker %s", err)
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
 	}
 
 	err = e.App.SetEnv(env)
 	if err != nil {
-		return fmt.Errorf("%s", err)
+		return exitError(EXIT_CONFIG, err)
 	}
 
 	err = e.Docker.Configure(e.App, 0)
 	if err != nil {
-		return err
+		return exitError(EXIT_CONFIG, err)
 	}
-	// Let's setup the containers with proper configurations
+
+	// defer the stop and delete of the containers
+	// and network, even if the setup fails
+	defer e.Stop()
+
+	// Let's setup the containers with proper configurations,
+	// failures that are not the build or the file are docker ones
 	err = e.Docker.SetupContainers()
 	if err != nil {
-		return err
+		return exitError(EXIT_DOCKER, err)
 	}
 
-	// defer the stop and delete of the container
-	defer e.Stop()
+	// The containers are stopped, don't start them
+	if e.Interrupted() {
+		return nil
+	}
 
 	// And launch the run
-	return e.Docker.Start()
+	err = exitError(EXIT_DOCKER, e.Docker.Start())
+
+	// Artifacts are extracted before the deferred
+	// stop removes the containers
+	aerr := e.ExtractArtifacts(err == nil)
+	if aerr != nil {
+		log.Errorf("%s", aerr)
+		if err == nil {
+			err = aerr
+		}
+	}
+
+	return err
 }
 
 func (e *Engine) Stop() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
 
 	log.Info("Stopping ...")
 	if !e.App.KeepAlive {
 		e.Docker.Stop()
 		e.Docker.Delete()
+		err := e.Docker.RemoveNetwork()
+		if err != nil {
+			log.Errorf("%s", err)
+		}
+	}
+}
+
+// Interrupt stops the containers gracefully, the run returns
+// as soon as they are stopped and removes them with its network
+func (e *Engine) Interrupt() {
+	e.lock.Lock()
+	e.interrupted = true
+	children := e.children
+	e.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, child := range children {
+		wg.Add(1)
+		go func(child *Engine) {
+			defer wg.Done()
+			child.Interrupt()
+		}(child)
+	}
+	wg.Wait()
+
+	if e.App == nil || e.Docker == nil {
+		return
+	}
+	if !e.App.KeepAlive {
+		log.Info("Stopping ...")
+	}
+	e.Docker.Interrupt(!e.App.KeepAlive)
+}
+
+// Interrupted tells if the run has been interrupted
+func (e *Engine) Interrupted() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.interrupted
+}
+
+// Kill removes the containers without waiting for
+// them to stop, when a graceful stop takes too long
+func (e *Engine) Kill() {
+	e.lock.Lock()
+	children := e.children
+	e.lock.Unlock()
+	for _, child := range children {
+		child.Kill()
+	}
+
+	if e.App == nil || e.App.KeepAlive || e.Docker.Client == nil {
+		return
 	}
+	log.Info("Killing ...")
+	e.Docker.Kill()
 }
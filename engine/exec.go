@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
+)
+
+// ExecOptions selects the container and the command run by Exec
+type ExecOptions struct {
+	RunID       string
+	Service     string
+	Cmd         []string
+	Interactive bool
+	Tty         bool
+}
+
+// Exec runs a command in a live service or controller container,
+// found by its hostname in the smuggler file, and returns its exit code
+func (e *Engine) Exec(opts ExecOptions) (int, error) {
+
+	if len(opts.Cmd) == 0 {
+		return -1, fmt.Errorf("No command to run in %s", opts.Service)
+	}
+
+	err := e.Docker.Connect()
+	if err != nil {
+		return -1, exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
+	}
+
+	container, err := e.findContainer(opts.RunID, opts.Service)
+	if err != nil {
+		return -1, err
+	}
+	if !container.Docker.State.Running {
+		return -1, fmt.Errorf("Container %s of %s is not running", container.Name, opts.Service)
+	}
+
+	log.Debugf("--> Running %s in %s", strings.Join(opts.Cmd, " "), container.Name)
+
+	if !opts.Interactive && !opts.Tty {
+		return container.Exec(opts.Cmd, os.Stdout, os.Stderr)
+	}
+	return container.ExecInteractive(opts.Cmd, os.Stdin, os.Stdout, os.Stderr, opts.Interactive, opts.Tty)
+}
+
+// findContainer resolves a hostname of the smuggler file to the
+// container of the run, or of a kept alive service
+func (e *Engine) findContainer(runID string, hostname string) (*Container, error) {
+
+	e.App.BuildApplications()
+
+	declared := []string{e.App.Hostname}
+	for h := range e.App.Applications {
+		declared = append(declared, h)
+	}
+	sort.Strings(declared[1:])
+
+	found := false
+	for _, h := range declared {
+		if h == hostname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Service %s not found in %s, expecting one of %s", hostname, e.App.FilePath, strings.Join(declared, ", "))
+	}
+
+	container := &Container{
+		Client:   e.Docker.Client,
+		Hostname: hostname,
+	}
+
+	// Latest container of the run with this hostname
+	labels := []string{LABEL_APP + "=" + e.App.GetAppName(), LABEL_HOST + "=" + hostname}
+	if runID != "" {
+		labels = append(labels, LABEL_RUN+"="+runID)
+	}
+	containers, err := e.Docker.ListContainers(true, labels...)
+	if err != nil {
+		return nil, err
+	}
+	var latest *dockerclient.APIContainers
+	for i, c := range containers {
+		if !isRunContainer(c) {
+			continue
+		}
+		if latest == nil || c.Created > latest.Created {
+			latest = &containers[i]
+		}
+	}
+	if latest != nil {
+		err := container.Inspect(latest.ID)
+		if err != nil {
+			return nil, err
+		}
+		container.Name = strings.TrimPrefix(container.Name, "/")
+		return container, nil
+	}
+
+	// Kept alive services created without labels
+	// are found by their name
+	if runID == "" {
+		name := e.App.getServiceName(hostname, e.App.GetAppName(), true)
+		if container.Exists(name) {
+			container.Name = strings.TrimPrefix(container.Name, "/")
+			return container, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No container found for %s, is the run alive ?", hostname)
+}
+
+// ExecInteractive runs the command inside the running container
+// with stdin attached and optionally a tty, and returns its exit code
+func (c *Container) ExecInteractive(cmd []string, in *os.File, out, errw io.Writer, stdin bool, tty bool) (int, error) {
+
+	exec, err := c.Client.CreateExec(dockerclient.CreateExecOptions{
+		Container:    c.Docker.ID,
+		Cmd:          cmd,
+		AttachStdin:  stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          tty,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	raw := tty && stdin && utils.IsTerminal(in.Fd())
+	if raw {
+		state, err := utils.MakeRaw(in.Fd())
+		if err != nil {
+			return -1, err
+		}
+		defer utils.RestoreTerminal(in.Fd(), state)
+	}
+
+	opts := dockerclient.StartExecOptions{
+		Tty:          tty,
+		OutputStream: out,
+		ErrorStream:  errw,
+		RawTerminal:  tty,
+	}
+	if stdin {
+		opts.InputStream = in
+	}
+	exe, err := c.Client.StartExecNonBlocking(exec.ID, opts)
+	if err != nil {
+		return -1, err
+	}
+	defer exe.Close()
+
+	if tty && utils.IsTerminal(in.Fd()) {
+		c.resizeExec(exec.ID, in.Fd())
+		resize := make(chan os.Signal, 1)
+		utils.NotifyResize(resize)
+		go func() {
+			for range resize {
+				c.resizeExec(exec.ID, in.Fd())
+			}
+		}()
+	}
+
+	err = exe.Wait()
+	if err != nil {
+		return -1, err
+	}
+
+	inspect, err := c.Client.InspectExec(exec.ID)
+	if err != nil {
+		return -1, err
+	}
+	return inspect.ExitCode, nil
+}
+
+// resizeExec sets the exec tty to the terminal size
+func (c *Container) resizeExec(id string, fd uintptr) {
+	height, width, err := utils.GetWinsize(fd)
+	if err != nil || height == 0 || width == 0 {
+		return
+	}
+	err = c.Client.ResizeExecTTY(id, height, width)
+	if err != nil {
+		log.Debugf("ERROR resizing exec in %s, %s", c.Hostname, err)
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// Exit codes of smg for its own failures, the exit code
+// of a failed run is the one of the controller
+const (
+	EXIT_SUCCESS     = 0
+	EXIT_DOCKER      = 69 // Docker host unreachable or refusing a call
+	EXIT_ERROR       = 70 // Anything else
+	EXIT_SERVICE     = 71 // A service didn't start or isn't healthy
+	EXIT_BUILD       = 73 // An image could not be built or pushed
+	EXIT_DEPLOY      = 74 // A deploy target failed
+	EXIT_CONFIG      = 78 // Invalid smuggler file, config or options
+	EXIT_INTERRUPTED = 130
+)
+
+// ExitError is an error with the exit code of smg
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// ExitCode is the exit code of smg for this error
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// exitError gives an exit code to the error,
+// errors that already have one keep it
+func exitError(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(interface {
+		ExitCode() int
+	}); ok {
+		return err
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// RunError is returned when the controller exits
+// with a non-zero code, which becomes the one of smg
+type RunError struct {
+	Code int
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("Run failed with code %d", e.Code)
+}
+
+// ExitCode is the exit code of the controller
+func (e *RunError) ExitCode() int {
+	return e.Code
+}
+
+// ExitCode returns the exit code of smg for the error
+func ExitCode(err error) int {
+	if err == nil {
+		return EXIT_SUCCESS
+	}
+	if e, ok := err.(interface {
+		ExitCode() int
+	}); ok {
+		return e.ExitCode()
+	}
+	return EXIT_ERROR
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, EXIT_SUCCESS},
+		{fmt.Errorf("unknown"), EXIT_ERROR},
+		{&RunError{Code: 2}, 2},
+		{&TimeoutError{Timeout: time.Minute}, EXIT_TIMEOUT},
+		{exitError(EXIT_DOCKER, fmt.Errorf("docker")), EXIT_DOCKER},
+		// The first exit code given is kept
+		{exitError(EXIT_DOCKER, exitError(EXIT_BUILD, fmt.Errorf("build"))), EXIT_BUILD},
+		{exitError(EXIT_DOCKER, &RunError{Code: 3}), 3},
+	}
+	for _, test := range tests {
+		if code := ExitCode(test.err); code != test.code {
+			t.Errorf("Expected exit code %d for %v, got %d", test.code, test.err, code)
+		}
+	}
+
+	if exitError(EXIT_DOCKER, nil) != nil {
+		t.Errorf("No error should stay nil")
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	a := &Application{Name: "smuggler", RunID: "0a1b2c3d"}
+	tests := []struct {
+		err    error
+		status string
+		code   int
+	}{
+		{nil, STATUS_SUCCESS, 0},
+		{&RunError{Code: 2}, STATUS_FAILED, 2},
+		{&TimeoutError{Timeout: time.Minute}, STATUS_TIMEOUT, EXIT_TIMEOUT},
+		{exitError(EXIT_BUILD, fmt.Errorf("build")), STATUS_ERROR, EXIT_BUILD},
+		{exitError(EXIT_INTERRUPTED, fmt.Errorf("interrupted")), STATUS_STOPPED, EXIT_INTERRUPTED},
+	}
+	for _, test := range tests {
+		s := NewRunSummary(a, "test", time.Now(), test.err)
+		if s.Status != test.status || s.ExitCode != test.code {
+			t.Errorf("Expected %s %d for %v, got %s %d", test.status, test.code, test.err, s.Status, s.ExitCode)
+		}
+		if s.App != "smuggler" || s.Environment != "test" || s.RunID != "0a1b2c3d" {
+			t.Errorf("Summary doesn't describe the run: %+v", s)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// HealthCheck describes how to know a service is ready,
+// exec is run inside the container, otherwise path is
+// requested on port with http, or port is simply opened
+type HealthCheck struct {
+	Port     string        `yaml:"port"`
+	Path     string        `yaml:"path"`
+	Status   int           `yaml:"status"`
+	Exec     string        `yaml:"exec"`
+	Interval time.Duration `yaml:"interval"`
+	Retries  int           `yaml:"retries"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Healthcheck defaults
+const (
+	HEALTH_INTERVAL = time.Second
+	HEALTH_RETRIES  = 30
+	HEALTH_TIMEOUT  = time.Second
+)
+
+// WaitHealthy checks the container until it's ready or
+// the retries are exhausted, host is the docker host
+// used to reach published ports
+func (c *Container) WaitHealthy(host string) error {
+	h := c.HealthCheck
+	if h == nil || c.healthy {
+		return nil
+	}
+
+	if h.Exec == "" && h.Port == "" {
+		return fmt.Errorf("Healthcheck of %s needs a port or an exec command", c.Hostname)
+	}
+
+	interval := h.Interval
+	if interval <= 0 {
+		interval = HEALTH_INTERVAL
+	}
+	retries := h.Retries
+	if retries <= 0 {
+		retries = HEALTH_RETRIES
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = HEALTH_TIMEOUT
+	}
+
+	log.Infof("--> Waiting for %s to be ready", c.Hostname)
+
+	var err error
+	for i := 0; i < retries; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		// A stopped service will never be ready
+		if !c.IsRunning(c.Docker.ID) {
+			return fmt.Errorf("Service %s is not running", c.Hostname)
+		}
+
+		err = c.check(host, timeout)
+		if err == nil {
+			log.Infof("Service %s is ready", c.Hostname)
+			c.healthy = true
+			return nil
+		}
+		log.Debugf("Service %s is not ready: %s", c.Hostname, err)
+	}
+
+	return fmt.Errorf("Service %s is not healthy after %d retries: %s", c.Hostname, retries, err)
+}
+
+func (c *Container) check(host string, timeout time.Duration) error {
+	h := c.HealthCheck
+
+	if h.Exec != "" {
+		return c.checkExec(timeout)
+	}
+
+	address, err := c.address(h.Port, host)
+	if err != nil {
+		return err
+	}
+
+	if h.Path != "" {
+		return checkHTTP(address, h.Path, h.Status, timeout)
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func (c *Container) checkExec(timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		out := bytes.NewBuffer(nil)
+		code, err := c.Exec([]string{"/bin/sh", "-c", c.HealthCheck.Exec}, out, out)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("%s exited with code %d: %s", c.HealthCheck.Exec, code, strings.TrimSpace(out.String()))
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("%s timed out", c.HealthCheck.Exec)
+	}
+}
+
+func checkHTTP(address string, path string, status int, timeout time.Duration) error {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + address + path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != status {
+		return fmt.Errorf("GET %s returned %d, expected %d", path, resp.StatusCode, status)
+	}
+	return nil
+}
+
+// address returns where the port of the container can be
+// reached, published ports first then the container ip
+func (c *Container) address(port string, host string) (string, error) {
+	err := c.Inspect(c.Docker.ID)
+	if err != nil {
+		return "", err
+	}
+	return c.settingsAddress(c.Docker.NetworkSettings, port, host)
+}
+
+// settingsAddress looks for the port in the network settings,
+// the ip of the container is the one on the network of the run
+func (c *Container) settingsAddress(settings *dockerclient.NetworkSettings, port string, host string) (string, error) {
+	if settings == nil {
+		return "", fmt.Errorf("No network settings for %s", c.Hostname)
+	}
+
+	if bindings := settings.Ports[c.ContainerPort(port)]; len(bindings) > 0 && bindings[0].HostPort != "" {
+		ip := bindings[0].HostIP
+		if ip == "" || ip == "0.0.0.0" {
+			ip = host
+		}
+		return net.JoinHostPort(ip, bindings[0].HostPort), nil
+	}
+
+	if n, ok := settings.Networks[c.Network]; ok && n.IPAddress != "" {
+		return net.JoinHostPort(n.IPAddress, c.ParsePort(port)), nil
+	}
+
+	if settings.IPAddress != "" {
+		return net.JoinHostPort(settings.IPAddress, c.ParsePort(port)), nil
+	}
+
+	return "", fmt.Errorf("Port %s of %s is not reachable", port, c.Hostname)
+}
+
+// HostIP returns the ip to reach containers published ports
+func (d *Docker) HostIP() string {
+	u, err := url.Parse(d.Host)
+	if err != nil || u.Scheme == "unix" || u.Host == "" {
+		return "127.0.0.1"
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host
+	}
+	return host
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+func TestHostIP(t *testing.T) {
+	hosts := map[string]string{
+		"unix:///var/run/docker.sock": "127.0.0.1",
+		"tcp://192.168.59.103:2376":   "192.168.59.103",
+		"https://docker.local:2376":   "docker.local",
+	}
+	for host, expected := range hosts {
+		d := &Docker{Host: host}
+		if ip := d.HostIP(); ip != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, host, ip)
+		}
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	address := strings.TrimPrefix(ts.URL, "http://")
+
+	if err := checkHTTP(address, "health", 0, time.Second); err != nil {
+		t.Errorf("%s", err)
+	}
+	if err := checkHTTP(address, "/other", 0, time.Second); err == nil {
+		t.Errorf("Expected an error for a 404")
+	}
+	if err := checkHTTP(address, "/other", http.StatusNotFound, time.Second); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestSettingsAddress(t *testing.T) {
+	c := &Container{Hostname: "redis"}
+	c.SetNetwork("smg-app-0a1b2c3d")
+
+	// Attached to the network of the run only
+	settings := &dockerclient.NetworkSettings{
+		Networks: map[string]dockerclient.ContainerNetwork{
+			"smg-app-0a1b2c3d": {IPAddress: "172.18.0.2"},
+		},
+	}
+	address, err := c.settingsAddress(settings, "6379", "127.0.0.1")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if address != "172.18.0.2:6379" {
+		t.Errorf("Expected 172.18.0.2:6379, got %s", address)
+	}
+
+	// Published ports are used first
+	settings.Ports = map[dockerclient.Port][]dockerclient.PortBinding{
+		"6379/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+	}
+	address, err = c.settingsAddress(settings, "6379", "127.0.0.1")
+	if err != nil || address != "127.0.0.1:32768" {
+		t.Errorf("Expected 127.0.0.1:32768, got %s (%v)", address, err)
+	}
+
+	c.Network = "smg-other"
+	if _, err := c.settingsAddress(settings, "80", "127.0.0.1"); err == nil {
+		t.Errorf("Expected an error for a port out of the network")
+	}
+}
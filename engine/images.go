@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jbdalido/smg/utils"
+)
+
+// ImageBuild is one of the images of a build entry, its
+// dockerfile is relative to its context, itself relative to smg.yml
+type ImageBuild struct {
+	Name       string            `yaml:"name"`
+	Dockerfile string            `yaml:"dockerfile"`
+	Context    string            `yaml:"context"`
+	Args       map[string]string `yaml:"args"`
+	DependsOn  []string          `yaml:"depends_on"`
+}
+
+// SortImages returns the indexes of the images ordered so that
+// each image is built after the ones it depends on, images
+// without dependencies keep the order of the smuggler file
+func (b *Build) SortImages() ([]int, error) {
+	index := make(map[string]int)
+	for i, img := range b.Images {
+		if img == nil || img.Name == "" {
+			return nil, fmt.Errorf("Image %d of the build has no name", i+1)
+		}
+		if _, ok := index[img.Name]; ok {
+			return nil, fmt.Errorf("Image %s is declared twice in the build", img.Name)
+		}
+		index[img.Name] = i
+	}
+
+	var (
+		sorted  []int
+		visited = make(map[string]bool)
+		path    []string
+		visit   func(n string) error
+	)
+
+	visit = func(n string) error {
+		if visited[n] {
+			return nil
+		}
+		for i, p := range path {
+			if p == n {
+				return fmt.Errorf("Dependency cycle between images: %s -> %s",
+					strings.Join(path[i:], " -> "), n)
+			}
+		}
+		path = append(path, n)
+		for _, dep := range b.Images[index[n]].DependsOn {
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("Image %s depends on unknown image %s", n, dep)
+			}
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		visited[n] = true
+		sorted = append(sorted, index[n])
+		return nil
+	}
+
+	for _, img := range b.Images {
+		err := visit(img.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// GetImagesFromApp returns the names of the images of the active
+// build, in the order of the smuggler file. They all get the tags
+// of GetNameFromAppWithTag
+func GetImagesFromApp(app *Application, tag string) []ImageName {
+	if app.ActiveBuild == nil || len(app.ActiveBuild.Images) == 0 {
+		return nil
+	}
+
+	base := GetNameFromAppWithTag(app, tag, BUILD)
+	var images []ImageName
+	for _, img := range app.ActiveBuild.Images {
+		i := ImageName{
+			Name:       img.Name,
+			Dockerfile: "Dockerfile",
+			Tags:       append([]string{}, base.Tags...),
+		}
+		n := strings.Split(i.Name, "/")
+		if len(n) == 2 {
+			i.Registry = n[0]
+		}
+		if img.Dockerfile != "" {
+			i.Dockerfile = img.Dockerfile
+		}
+		images = append(images, i)
+	}
+	return images
+}
+
+// BuildImages builds the images of the active build in dependency
+// order, and pushes them only once they are all built. It returns
+// the first image of the build, the one given to the deploy targets
+func (d *Docker) BuildImages(push bool, tag string) (ImageName, error) {
+	order, err := d.App.ActiveBuild.SortImages()
+	if err != nil {
+		return ImageName{}, exitError(EXIT_CONFIG, err)
+	}
+
+	// Each image is built from a copy of its own context,
+	// the copy of the whole application made by the shared
+	// builder is never used, it is removed right away
+	d.Builder.Cleanup()
+
+	images := GetImagesFromApp(d.App, tag)
+	var built []ImageName
+	for n, i := range order {
+		img := d.App.ActiveBuild.Images[i]
+
+		context := filepath.Join(d.App.WorkingDir, img.Context)
+		builder := NewBuilder(context, d.Client)
+		if builder == nil {
+			d.removeImages(built)
+			return ImageName{}, exitError(EXIT_CONFIG, fmt.Errorf("Context %s of image %s does not exist", context, img.Name))
+		}
+		builder.Labels = d.buildLabels()
+
+		// The args of the image win over the ones of the
+		// build entry, but not over the --build-arg flags
+		builder.Options = d.Builder.Options
+		builder.Options.Args = make(map[string]string)
+		for k, v := range d.Builder.Options.Args {
+			builder.Options.Args[k] = v
+		}
+		for k, v := range img.Args {
+			if _, ok := d.App.BuildArgs[k]; !ok {
+				builder.Options.Args[k] = utils.EnvResolver(v)
+			}
+		}
+
+		// An image built FROM another image of the build uses the
+		// local one just built, nothing is pulled from the registry
+		uptodate := true
+		content, err := utils.OpenAndReadFile(filepath.Join(context, images[i].Dockerfile))
+		if err == nil && fromImages(content, images) {
+			uptodate = false
+			if builder.Options.Pull {
+				log.Warnf("--> Pull ignored for image %s, it is built from an image of the build", img.Name)
+				builder.Options.Pull = false
+			}
+		}
+
+		log.Infof("--> Building image %s (%d/%d)", images[i].ToString(), n+1, len(order))
+		err = builder.MakeImage(images[i].Dockerfile, images[i], uptodate, true)
+		builder.Cleanup()
+		if err != nil {
+			d.removeImages(built)
+			return ImageName{}, exitError(EXIT_BUILD, fmt.Errorf("Image %s: %s", img.Name, err))
+		}
+		d.built(images[i])
+		built = append(built, images[i])
+	}
+
+	if push {
+		for _, image := range images {
+			digest, err := d.Builder.PushImage(image)
+			if err != nil {
+				return ImageName{}, exitError(EXIT_BUILD, err)
+			}
+			d.pushed(image, digest)
+		}
+	}
+
+	return images[0], nil
+}
+
+// removeImages removes the images already built
+// when one of the next images of the build fails
+func (d *Docker) removeImages(images []ImageName) {
+	for _, image := range images {
+		log.Infof("--> Removing image %s, the build failed", image.ToString())
+		d.RemoveImage(image)
+	}
+}
+
+// fromImages tells if one of the stages of the Dockerfile
+// is built FROM one of the images, whatever its tag
+func fromImages(content []byte, images []ImageName) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		from := ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "--") {
+				from = f
+				break
+			}
+		}
+		if i := strings.Index(from, "@"); i >= 0 {
+			from = from[:i]
+		}
+		if i := strings.LastIndex(from, ":"); i > strings.LastIndex(from, "/") {
+			from = from[:i]
+		}
+		for _, image := range images {
+			if from == image.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
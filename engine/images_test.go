@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jbdalido/smg/utils"
+)
+
+func TestSortImages(t *testing.T) {
+	b := &Build{
+		Images: []*ImageBuild{
+			{Name: "org/api", DependsOn: []string{"org/base"}},
+			{Name: "org/worker", DependsOn: []string{"org/base"}},
+			{Name: "org/base"},
+		},
+	}
+	order, err := b.SortImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, []int{2, 0, 1}) {
+		t.Errorf("Expected the base image first, got %v", order)
+	}
+
+	invalid := []*Build{
+		{Images: []*ImageBuild{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}},
+		{Images: []*ImageBuild{{Name: "a", DependsOn: []string{"c"}}}},
+		{Images: []*ImageBuild{{Name: "a"}, {Name: "a"}}},
+		{Images: []*ImageBuild{{Dockerfile: "Dockerfile"}}},
+	}
+	for _, b := range invalid {
+		if _, err := b.SortImages(); err == nil {
+			t.Errorf("Expected an error for %v", b.Images)
+		}
+	}
+}
+
+func TestGetImagesFromApp(t *testing.T) {
+	a := &Application{
+		Name:          "smuggler",
+		UseDockerfile: true,
+		Git: &utils.Git{
+			Branch:     "feature/api",
+			LastCommit: &utils.Commit{ID: "0123456789abcdef", Short: "012345678"},
+		},
+		ActiveBuild: &Build{
+			Images: []*ImageBuild{
+				{Name: "registry.local/api", Dockerfile: "api.dockerfile"},
+				{Name: "worker"},
+			},
+		},
+	}
+
+	images := GetImagesFromApp(a, "")
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 images, got %v", images)
+	}
+	tags := []string{"feature.api", "012345678", "latest"}
+	for _, i := range images {
+		if !reflect.DeepEqual(i.Tags, tags) {
+			t.Errorf("Expected tags %v for %s, got %v", tags, i.Name, i.Tags)
+		}
+	}
+	if images[0].Registry != "registry.local" || images[0].Dockerfile != "api.dockerfile" {
+		t.Errorf("Unexpected first image %+v", images[0])
+	}
+	if images[1].Dockerfile != "Dockerfile" {
+		t.Errorf("Expected the default Dockerfile, got %s", images[1].Dockerfile)
+	}
+
+	a.ActiveBuild.Images = nil
+	if images := GetImagesFromApp(a, ""); images != nil {
+		t.Errorf("Expected no images, got %v", images)
+	}
+}
+
+func TestFromImages(t *testing.T) {
+	images := []ImageName{
+		{Name: "registry:5000/org/base", Tags: []string{"012345678"}},
+		{Name: "org/api", Tags: []string{"012345678"}},
+	}
+	dockerfiles := map[string]bool{
+		"FROM registry:5000/org/base:012345678\nRUN make":                 true,
+		"FROM golang:1.8 AS build\nRUN make\nFROM registry:5000/org/base": true,
+		"from --platform=linux/amd64 registry:5000/org/base@sha256:4f53":  true,
+		"FROM registry:5000/org/base-dev\nRUN make":                       false,
+		"FROM debian:jessie\nRUN make":                                    false,
+	}
+	for content, expected := range dockerfiles {
+		if fromImages([]byte(content), images) != expected {
+			t.Errorf("Expected %v for %q", expected, content)
+		}
+	}
+}
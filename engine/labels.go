@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels set on everything smuggler creates
+const (
+	LABEL_VERSION = "io.smuggler.version"
+	LABEL_APP     = "io.smuggler.app"
+	LABEL_ENV     = "io.smuggler.env"
+	LABEL_RUN     = "io.smuggler.run"
+	LABEL_ROLE    = "io.smuggler.role"
+	LABEL_HOST    = "io.smuggler.hostname"
+	LABEL_BUILD   = "io.smuggler.build"
+	LABEL_DEPLOY  = "io.smuggler.deploy"
+	LABEL_BRANCH  = "io.smuggler.git.branch"
+	LABEL_COMMIT  = "io.smuggler.git.commit"
+	LABEL_TAGS    = "io.smuggler.git.tags"
+	// Containers of keepalive runs, kept by smg clean --keepalive
+	LABEL_KEEPALIVE = "io.smuggler.keepalive"
+	// Creation time of the networks, set as a driver option
+	// since networks don't have labels or dates in this api
+	LABEL_CREATED = "io.smuggler.created"
+)
+
+// escapeRegexp reads the escape directive of a Dockerfile
+var escapeRegexp = regexp.MustCompile(`(?mi)\A(?:#[^\n]*\n)*?#\s*escape\s*=\s*(\S)`)
+
+// Roles of the containers and images
+const (
+	ROLE_CONTROLLER = "controller"
+	ROLE_SERVICE    = "service"
+	ROLE_BASE       = "base"
+	ROLE_BUILD      = "build"
+	ROLE_DEPLOY     = "deploy"
+)
+
+// Labels returns the labels describing the run or
+// the build for a container or an image with the given role
+func (a *Application) Labels(role string) map[string]string {
+	labels := map[string]string{
+		LABEL_VERSION: VERSION,
+		LABEL_APP:     a.GetAppName(),
+		LABEL_ROLE:    role,
+	}
+
+	if a.Environment != "" {
+		labels[LABEL_ENV] = a.Environment
+	}
+	if a.RunID != "" {
+		labels[LABEL_RUN] = a.RunID
+	}
+	if a.BuildTarget != "" {
+		labels[LABEL_BUILD] = a.BuildTarget
+	}
+
+	if a.KeepAlive {
+		labels[LABEL_KEEPALIVE] = "true"
+	}
+
+	if a.Git != nil {
+		if a.Git.Branch != "" {
+			labels[LABEL_BRANCH] = a.Git.Branch
+		}
+		if a.Git.LastCommit != nil && a.Git.LastCommit.ID != "" {
+			labels[LABEL_COMMIT] = a.Git.LastCommit.ID
+		}
+		if len(a.Git.Tag) > 0 {
+			labels[LABEL_TAGS] = strings.Join(a.Git.Tag, ",")
+		}
+	}
+
+	return labels
+}
+
+// ImageLabels returns the labels of an image built with the
+// given role, without the run id that would change on every
+// run and invalidate the build cache
+func (a *Application) ImageLabels(role string) map[string]string {
+	labels := a.Labels(role)
+	delete(labels, LABEL_RUN)
+	return labels
+}
+
+// GetAppName returns the name of the application
+// as written in the smuggler file
+func (a *Application) GetAppName() string {
+	if a.AppName != "" {
+		return a.AppName
+	}
+	return a.Name
+}
+
+// SetLabels adds the labels to the container config
+func (c *Container) SetLabels(labels map[string]string) {
+	if c.ContainerConfig.Labels == nil {
+		c.ContainerConfig.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		c.ContainerConfig.Labels[k] = v
+	}
+}
+
+// labelInstruction returns the LABEL instruction
+// setting the labels in a Dockerfile
+func labelInstruction(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var instruction bytes.Buffer
+	instruction.WriteString("LABEL")
+	for _, k := range keys {
+		instruction.WriteString(fmt.Sprintf(" %s=%s", strconv.Quote(k), strconv.Quote(labels[k])))
+	}
+	return instruction.String()
+}
+
+// endInstruction ends the last instruction of the Dockerfile with a
+// newline, a line continued at the end of the file would swallow
+// the next one, its escape character is dropped
+func endInstruction(content []byte) []byte {
+	escape := "\\"
+	if m := escapeRegexp.FindSubmatch(content); m != nil {
+		escape = string(m[1])
+	}
+
+	content = bytes.TrimRight(content, " \t\r\n")
+	if bytes.HasSuffix(content, []byte(escape)) {
+		content = bytes.TrimRight(content[:len(content)-len(escape)], " \t")
+	}
+	return append(content, '\n')
+}
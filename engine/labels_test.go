@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/jbdalido/smg/utils"
+)
+
+func TestLabels(t *testing.T) {
+	a := &Application{
+		Name:        "smuggler",
+		Environment: "default",
+		RunID:       "0a1b2c3d",
+		BuildTarget: "master",
+		Git: &utils.Git{
+			Branch:     "master",
+			LastCommit: &utils.Commit{ID: "8f9c7fab24d777e5ade6ed75f01e15e4b0fde79c"},
+			Tag:        []string{"v1.0.0", "stable"},
+		},
+	}
+
+	labels := a.Labels(ROLE_CONTROLLER)
+	expected := map[string]string{
+		LABEL_VERSION: VERSION,
+		LABEL_APP:     "smuggler",
+		LABEL_ENV:     "default",
+		LABEL_RUN:     "0a1b2c3d",
+		LABEL_ROLE:    ROLE_CONTROLLER,
+		LABEL_BUILD:   "master",
+		LABEL_BRANCH:  "master",
+		LABEL_COMMIT:  "8f9c7fab24d777e5ade6ed75f01e15e4b0fde79c",
+		LABEL_TAGS:    "v1.0.0,stable",
+	}
+	for k, v := range expected {
+		if labels[k] != v {
+			t.Errorf("Expected %s=%s, got %s", k, v, labels[k])
+		}
+	}
+	if _, ok := labels[LABEL_KEEPALIVE]; ok {
+		t.Errorf("Keepalive label set without keepalive")
+	}
+}
+
+func TestLabelInstruction(t *testing.T) {
+	instruction := labelInstruction(map[string]string{
+		LABEL_ROLE: ROLE_BUILD,
+		LABEL_APP:  "smug \"gler\"",
+	})
+	expected := `LABEL "io.smuggler.app"="smug \"gler\"" "io.smuggler.role"="build"`
+	if instruction != expected {
+		t.Errorf("Expected %s, got %s", expected, instruction)
+	}
+}
+
+func TestImageLabels(t *testing.T) {
+	a := &Application{Name: "smuggler", RunID: "0a1b2c3d"}
+	if _, ok := a.ImageLabels(ROLE_BUILD)[LABEL_RUN]; ok {
+		t.Errorf("Run id set on an image")
+	}
+	if a.Labels(ROLE_CONTROLLER)[LABEL_RUN] != "0a1b2c3d" {
+		t.Errorf("Run id missing on a container")
+	}
+}
+
+func TestEndInstruction(t *testing.T) {
+	files := map[string]string{
+		"FROM debian\nRUN make":              "FROM debian\nRUN make\n",
+		"FROM debian\nRUN make \\\n":         "FROM debian\nRUN make\n",
+		"FROM debian\nRUN make \\\n\n":       "FROM debian\nRUN make\n",
+		"# escape=`\nFROM debian\nRUN dir `": "# escape=`\nFROM debian\nRUN dir\n",
+		"FROM debian\nRUN echo `":            "FROM debian\nRUN echo `\n",
+	}
+	for content, expected := range files {
+		if end := string(endInstruction([]byte(content))); end != expected {
+			t.Errorf("Expected %q, got %q", expected, end)
+		}
+	}
+}
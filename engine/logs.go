@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
+)
+
+// LogsOptions selects the logs shown by Logs
+type LogsOptions struct {
+	RunID      string
+	Services   []string
+	Follow     bool
+	Since      string
+	Tail       string
+	Timestamps bool
+}
+
+// RunContainers returns the containers of a run of the
+// application, the latest one if runID is empty
+func (e *Engine) RunContainers(runID string) ([]dockerclient.APIContainers, error) {
+	containers, err := e.Docker.ListContainers(true, LABEL_APP+"="+e.App.GetAppName())
+	if err != nil {
+		return nil, err
+	}
+
+	if runID == "" {
+		var latest int64
+		for _, c := range containers {
+			if isRunContainer(c) && c.Created > latest {
+				latest = c.Created
+				runID = c.Labels[LABEL_RUN]
+			}
+		}
+	}
+	if runID == "" {
+		return nil, fmt.Errorf("No run found for %s", e.App.GetAppName())
+	}
+
+	var run []dockerclient.APIContainers
+	for _, c := range containers {
+		if isRunContainer(c) && c.Labels[LABEL_RUN] == runID {
+			run = append(run, c)
+		}
+	}
+	if len(run) == 0 {
+		return nil, fmt.Errorf("No container found for run %s", runID)
+	}
+	return run, nil
+}
+
+// containerHostname returns the hostname of a run container,
+// or its name for containers created before hostname labels
+func containerHostname(c dockerclient.APIContainers) string {
+	if h := c.Labels[LABEL_HOST]; h != "" {
+		return h
+	}
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID[:12]
+}
+
+// matchContainer tells if the container is one of the
+// services, matched by hostname, name or controller role
+func matchContainer(c dockerclient.APIContainers, services []string) bool {
+	if len(services) == 0 {
+		return true
+	}
+	for _, s := range services {
+		if s == containerHostname(c) || s == c.Labels[LABEL_ROLE] {
+			return true
+		}
+		for _, name := range c.Names {
+			if strings.TrimPrefix(name, "/") == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Logs prints the logs of the containers of a run,
+// each line prefixed by the container hostname
+func (e *Engine) Logs(opts LogsOptions) error {
+
+	err := e.Docker.Connect()
+	if err != nil {
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
+	}
+
+	since, err := parseSince(opts.Since)
+	if err != nil {
+		return err
+	}
+
+	run, err := e.RunContainers(opts.RunID)
+	if err != nil {
+		return err
+	}
+
+	var containers []dockerclient.APIContainers
+	width := 0
+	for _, c := range run {
+		if matchContainer(c, opts.Services) {
+			containers = append(containers, c)
+			if l := len(containerHostname(c)); l > width {
+				width = l
+			}
+		}
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("No container matches %s", strings.Join(opts.Services, ", "))
+	}
+
+	tail := opts.Tail
+	if tail == "" {
+		tail = "all"
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range containers {
+		wg.Add(1)
+		go func(c dockerclient.APIContainers) {
+			defer wg.Done()
+
+			out := utils.NewStdPrefixed(fmt.Sprintf("%-*s |", width, containerHostname(c)))
+			err := e.Docker.Client.Logs(dockerclient.LogsOptions{
+				Container:    c.ID,
+				OutputStream: out,
+				ErrorStream:  out,
+				Follow:       opts.Follow,
+				Stdout:       true,
+				Stderr:       true,
+				Since:        since,
+				Tail:         tail,
+				Timestamps:   opts.Timestamps,
+			})
+			out.Flush()
+			if err != nil {
+				log.Errorf("Error - Cannot get logs of %s: %s", containerHostname(c), err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// parseSince reads a duration (10m) or a RFC3339
+// date and returns the unix timestamp
+func parseSince(since string) (int64, error) {
+	if since == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d).Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid since %s, expecting a duration or a RFC3339 date", since)
+	}
+	return t.Unix(), nil
+}
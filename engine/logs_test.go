@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+func TestMatchContainer(t *testing.T) {
+	c := dockerclient.APIContainers{
+		ID:     "0123456789abcdef",
+		Names:  []string{"/cassandra-smuggler-31234"},
+		Labels: map[string]string{LABEL_HOST: "cassandra", LABEL_ROLE: ROLE_SERVICE},
+	}
+
+	if !matchContainer(c, nil) {
+		t.Errorf("No service should match every container")
+	}
+	if !matchContainer(c, []string{"mongo", "cassandra"}) {
+		t.Errorf("Container should match its hostname")
+	}
+	if !matchContainer(c, []string{"cassandra-smuggler-31234"}) {
+		t.Errorf("Container should match its name")
+	}
+	if matchContainer(c, []string{ROLE_CONTROLLER}) {
+		t.Errorf("Service should not match the controller")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	since, err := parseSince("10m")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if d := time.Now().Unix() - since; d < 599 || d > 601 {
+		t.Errorf("Expected 10 minutes ago, got %d seconds", d)
+	}
+
+	since, err = parseSince("2016-06-17T05:54:28Z")
+	if err != nil || since != 1466142868 {
+		t.Errorf("Expected 1466142868, got %d (%v)", since, err)
+	}
+
+	if _, err := parseSince("yesterday"); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"time"
+)
+
+// Digests are printed by the daemon at the end of a push,
+// ex: latest: digest: sha256:... size: 1234
+var digestRegexp = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// BuildManifest describes a build for the jobs deploying
+// its images, written by smg build --output
+type BuildManifest struct {
+	App string `json:"app"`
+	// Build is the key of the build definition matched
+	Build       string        `json:"build"`
+	Environment string        `json:"environment,omitempty"`
+	Tag         string        `json:"tag,omitempty"`
+	Status      string        `json:"status"`
+	ExitCode    int           `json:"exit_code"`
+	Error       string        `json:"error,omitempty"`
+	Started     time.Time     `json:"started"`
+	Duration    time.Duration `json:"duration"`
+	Pushed      bool          `json:"pushed"`
+	// Onlyif is the summary of the tests run before the build
+	Onlyif *RunSummary      `json:"onlyif,omitempty"`
+	Git    *GitManifest     `json:"git,omitempty"`
+	Images []*ImageManifest `json:"images"`
+}
+
+// GitManifest is the commit the images are built from
+type GitManifest struct {
+	Branch      string   `json:"branch,omitempty"`
+	Commit      string   `json:"commit,omitempty"`
+	ShortCommit string   `json:"short_commit,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// ImageManifest is a built image, its digest
+// is the one given by the registry when pushed
+type ImageManifest struct {
+	Name      string   `json:"name"`
+	ID        string   `json:"id,omitempty"`
+	Tags      []string `json:"tags"`
+	Refs      []string `json:"refs"`
+	Pushed    bool     `json:"pushed"`
+	Digest    string   `json:"digest,omitempty"`
+	DigestRef string   `json:"digest_ref,omitempty"`
+}
+
+// NewBuildManifest returns the manifest of the build
+// started at started and ended by err
+func (e *Engine) NewBuildManifest(env string, tag string, started time.Time, err error) *BuildManifest {
+	s := NewRunSummary(e.App, env, started, err)
+	m := &BuildManifest{
+		App:         s.App,
+		Build:       e.App.BuildTarget,
+		Environment: env,
+		Tag:         tag,
+		Status:      s.Status,
+		ExitCode:    s.ExitCode,
+		Error:       s.Error,
+		Started:     started,
+		Duration:    s.Duration,
+		Onlyif:      e.Onlyif,
+		Images:      e.Docker.Built,
+	}
+	if m.Images == nil {
+		m.Images = []*ImageManifest{}
+	}
+	m.Pushed = len(m.Images) > 0
+	for _, i := range m.Images {
+		m.Pushed = m.Pushed && i.Pushed
+	}
+
+	if g := e.App.Git; g != nil {
+		m.Git = &GitManifest{
+			Branch: g.Branch,
+			Tags:   g.Tag,
+		}
+		if g.LastCommit != nil {
+			m.Git.Commit = g.LastCommit.ID
+			m.Git.ShortCommit = g.LastCommit.Short
+		}
+	}
+	return m
+}
+
+// WriteFile writes the manifest as JSON in path
+func (m *BuildManifest) WriteFile(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// built records an image once built, with its id. It is read
+// from the first tag of the build, latest may not be one of them
+func (d *Docker) built(name ImageName) {
+	i := &ImageManifest{
+		Name: name.Name,
+		Tags: name.Tags,
+		Refs: name.GetAllNames(),
+	}
+	if image, err := d.Client.InspectImage(i.Refs[0]); err == nil {
+		i.ID = image.ID
+	}
+	d.Built = append(d.Built, i)
+}
+
+// pushed records a pushed image, with the
+// digest given by the registry if any
+func (d *Docker) pushed(name ImageName, digest string) {
+	for _, i := range d.Built {
+		if i.Name != name.Name {
+			continue
+		}
+		i.Pushed = true
+		if digest != "" {
+			i.Digest = digest
+			i.DigestRef = name.Name + "@" + digest
+		}
+	}
+}
+
+// pushDigest returns the digest printed at the end of a push
+func pushDigest(output string) string {
+	m := digestRegexp.FindAllStringSubmatch(output, -1)
+	if len(m) == 0 {
+		return ""
+	}
+	return m[len(m)-1][1]
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jbdalido/smg/utils"
+)
+
+const testDigest = "sha256:4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"
+
+func TestPushDigest(t *testing.T) {
+	output := "The push refers to a repository [docker.io/jbdalido/smg]\n" +
+		"5f70bf18a086: Pushed\n" +
+		"latest: digest: " + testDigest + " size: 1357\n"
+
+	if d := pushDigest(output); d != testDigest {
+		t.Errorf("Expected digest %s, got %s", testDigest, d)
+	}
+	if d := pushDigest("5f70bf18a086: Pushed\n"); d != "" {
+		t.Errorf("Expected no digest, got %s", d)
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	e := &Engine{
+		App: &Application{
+			Name:        "smuggler",
+			BuildTarget: "master",
+			Git: &utils.Git{
+				Branch: "master",
+				LastCommit: &utils.Commit{
+					ID:    "8f9c7fab24d777e5ade6ed75f01e15e4b0fde79c",
+					Short: "8f9c7fa",
+				},
+				Tag: []string{"v1.0.0"},
+			},
+		},
+		Docker: &Docker{
+			Built: []*ImageManifest{
+				{Name: "jbdalido/api", Tags: []string{"8f9c7fa", "latest"}},
+				{Name: "jbdalido/worker", Tags: []string{"8f9c7fa", "latest"}},
+			},
+		},
+		Onlyif: &RunSummary{Environment: "test", Status: STATUS_SUCCESS},
+	}
+
+	api := ImageName{Name: "jbdalido/api", Tags: []string{"8f9c7fa", "latest"}}
+	e.Docker.pushed(api, testDigest)
+
+	m := e.NewBuildManifest("", "", time.Now(), nil)
+	if m.Build != "master" || m.Status != STATUS_SUCCESS || m.Onlyif == nil {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	if m.Git == nil || m.Git.ShortCommit != "8f9c7fa" || len(m.Git.Tags) != 1 {
+		t.Errorf("Unexpected git manifest %+v", m.Git)
+	}
+	if m.Pushed {
+		t.Errorf("Build marked as pushed with an image not pushed")
+	}
+
+	i := m.Images[0]
+	if !i.Pushed || i.Digest != testDigest || i.DigestRef != "jbdalido/api@"+testDigest {
+		t.Errorf("Unexpected image manifest %+v", i)
+	}
+	if m.Images[1].Pushed || m.Images[1].Digest != "" {
+		t.Errorf("Image %s marked as pushed", m.Images[1].Name)
+	}
+
+	e.Docker.pushed(ImageName{Name: "jbdalido/worker"}, "")
+	m = e.NewBuildManifest("", "", time.Now(), fmt.Errorf("Deploy failed"))
+	if !m.Pushed || m.Status != STATUS_ERROR || m.Error != "Deploy failed" {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+}
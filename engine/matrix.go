@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MATRIX_IMAGE is the axis of the base images
+// of the matrix, in filters and variant names
+const MATRIX_IMAGE = "image"
+
+// Matrix expands the application into variants, one for
+// each combination of its images and environment variables
+type Matrix struct {
+	Image []string            `yaml:"image"`
+	Env   map[string][]string `yaml:"env"`
+}
+
+// MatrixVariant is one combination of the matrix, its
+// image replaces the one of the application and its
+// variables override the env of the application
+type MatrixVariant struct {
+	Image string   `json:"image,omitempty"`
+	Env   []string `json:"env,omitempty"`
+}
+
+// GetMatrix returns the matrix of the environment,
+// its overlay can replace the one of the application
+func (a *Application) GetMatrix(env string) *Matrix {
+	if o, ok := a.Environments[env]; ok && o != nil && o.Matrix != nil {
+		return o.Matrix
+	}
+	return a.Matrix
+}
+
+// Variants returns every combination of the matrix, the
+// images first then the variables sorted by name
+func (m *Matrix) Variants() []*MatrixVariant {
+	variants := []*MatrixVariant{{}}
+	if len(m.Image) > 0 {
+		variants = nil
+		for _, image := range m.Image {
+			variants = append(variants, &MatrixVariant{Image: image})
+		}
+	}
+
+	keys := make([]string, 0, len(m.Env))
+	for k := range m.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if len(m.Env[k]) == 0 {
+			continue
+		}
+		var expanded []*MatrixVariant
+		for _, v := range variants {
+			for _, value := range m.Env[k] {
+				env := append(append([]string{}, v.Env...), k+"="+value)
+				expanded = append(expanded, &MatrixVariant{Image: v.Image, Env: env})
+			}
+		}
+		variants = expanded
+	}
+	return variants
+}
+
+// String returns the variant as a filter, ex: image=debian:jessie,PYTHON=3.5
+func (v *MatrixVariant) String() string {
+	var axes []string
+	if v.Image != "" {
+		axes = append(axes, MATRIX_IMAGE+"="+v.Image)
+	}
+	axes = append(axes, v.Env...)
+	return strings.Join(axes, ",")
+}
+
+// Application returns the overlay applied to the
+// application to run the variant
+func (v *MatrixVariant) Application() *Application {
+	return &Application{
+		Image: v.Image,
+		Env:   v.Env,
+	}
+}
+
+// Match tells if the variant matches every axis
+// of the filter, values can be shell patterns
+func (v *MatrixVariant) Match(filter map[string]string) bool {
+	values := map[string]string{MATRIX_IMAGE: v.Image}
+	for _, e := range v.Env {
+		kv := strings.SplitN(e, "=", 2)
+		values[kv[0]] = kv[1]
+	}
+
+	for k, pattern := range filter {
+		value, ok := values[k]
+		if !ok {
+			return false
+		}
+		match, err := path.Match(pattern, value)
+		if err != nil || !match {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMatrixFilter parses a filter of variants,
+// ex: image=debian:*,PYTHON=3.5
+func ParseMatrixFilter(f string) (map[string]string, error) {
+	filter := make(map[string]string)
+	for _, axis := range strings.Split(f, ",") {
+		axis = strings.TrimSpace(axis)
+		if axis == "" {
+			continue
+		}
+		kv := strings.SplitN(axis, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid matrix filter %s, expected axis=value", axis)
+		}
+		if _, err := path.Match(kv[1], ""); err != nil {
+			return nil, fmt.Errorf("Invalid matrix filter %s, %s", axis, err)
+		}
+		filter[kv[0]] = kv[1]
+	}
+	return filter, nil
+}
+
+// PrintMatrix logs the status of the variants as a grid,
+// an image by column and a combination of variables by line
+func PrintMatrix(summaries []*RunSummary) {
+	var images, lines []string
+	status := make(map[string]string)
+	for _, s := range summaries {
+		if s.Variant == nil {
+			continue
+		}
+		image := s.Variant.Image
+		if image == "" {
+			image = "-"
+		}
+		line := strings.Join(append([]string{s.Environment}, s.Variant.Env...), " ")
+		images = mergeList(images, []string{image})
+		lines = mergeList(lines, []string{line})
+		status[line+"\t"+image] = s.Status
+	}
+	if len(images) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\n", strings.Join(images, "\t"))
+	for _, line := range lines {
+		cells := []string{line}
+		for _, image := range images {
+			s, ok := status[line+"\t"+image]
+			if !ok {
+				s = "-"
+			}
+			cells = append(cells, s)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+
+	log.Infof("--> Matrix")
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Infof("    %s", line)
+	}
+}
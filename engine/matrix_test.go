@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatrixVariants(t *testing.T) {
+	m := &Matrix{
+		Image: []string{"debian:jessie", "ubuntu:14.04"},
+		Env: map[string][]string{
+			"PYTHON": {"2.7", "3.5"},
+			"DB":     {"mysql"},
+		},
+	}
+
+	var variants []string
+	for _, v := range m.Variants() {
+		variants = append(variants, v.String())
+	}
+	expected := []string{
+		"image=debian:jessie,DB=mysql,PYTHON=2.7",
+		"image=debian:jessie,DB=mysql,PYTHON=3.5",
+		"image=ubuntu:14.04,DB=mysql,PYTHON=2.7",
+		"image=ubuntu:14.04,DB=mysql,PYTHON=3.5",
+	}
+	if !reflect.DeepEqual(variants, expected) {
+		t.Errorf("Expected %v, got %v", expected, variants)
+	}
+
+	// Without images the application keeps its own
+	m = &Matrix{Env: map[string][]string{"PYTHON": {"2.7", "3.5"}}}
+	if v := m.Variants(); len(v) != 2 || v[0].Image != "" || v[1].String() != "PYTHON=3.5" {
+		t.Errorf("Expected a variant by value, got %v", v)
+	}
+}
+
+func TestMatrixFilter(t *testing.T) {
+	v := &MatrixVariant{Image: "debian:jessie", Env: []string{"PYTHON=3.5"}}
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"", true},
+		{"image=debian:jessie", true},
+		{"image=debian:*,PYTHON=3.5", true},
+		{"image=ubuntu:*", false},
+		{"PYTHON=2.7", false},
+		{"DB=mysql", false},
+	}
+	for _, test := range tests {
+		filter, err := ParseMatrixFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Match(filter) != test.match {
+			t.Errorf("Expected %v for %s", test.match, test.filter)
+		}
+	}
+
+	for _, f := range []string{"debian", "=3.5", "image=["} {
+		if _, err := ParseMatrixFilter(f); err == nil {
+			t.Errorf("Expected an error for %s", f)
+		}
+	}
+}
+
+func TestSetEnvVariant(t *testing.T) {
+	a := &Application{
+		Name:     "smuggler",
+		Image:    "debian:jessie",
+		Env:      []string{"PYTHON=2.7", "A=1"},
+		Commands: map[string][]string{"test": {"make test"}},
+		Environments: map[string]*Application{
+			"test": {Image: "debian:wheezy"},
+		},
+		Variant: &MatrixVariant{Image: "ubuntu:14.04", Env: []string{"PYTHON=3.5"}},
+	}
+
+	err := a.SetEnv("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Image != "ubuntu:14.04" {
+		t.Errorf("Expected the image of the variant, got %s", a.Image)
+	}
+	if !reflect.DeepEqual(a.Env, []string{"PYTHON=3.5", "A=1"}) {
+		t.Errorf("Expected the env of the variant, got %v", a.Env)
+	}
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jbdalido/smg/utils"
+)
+
+// envRun is an environment to run, in a
+// variant of its matrix if it has one
+type envRun struct {
+	env     string
+	variant *MatrixVariant
+}
+
+func (r envRun) String() string {
+	if r.variant == nil {
+		return r.env
+	}
+	return r.env + " " + r.variant.String()
+}
+
+// RunAll runs the environments one after the other, or parallel
+// at a time, each in its own stack with its own run id. Environments
+// with a matrix are run once for each of its variants. It returns
+// the error of the first run that failed, in the given order
+func (e *Engine) RunAll(envs []string, parallel int) error {
+	if len(envs) == 0 {
+		return exitError(EXIT_CONFIG, fmt.Errorf("No environment to run in %s", e.App.FilePath))
+	}
+
+	runs, err := e.matrixRuns(envs)
+	if err != nil {
+		return exitError(EXIT_CONFIG, err)
+	}
+	if len(runs) == 1 && runs[0].variant == nil {
+		return e.Run(runs[0].env)
+	}
+
+	// Kept alive containers are named after the service only,
+	// and a shared folder gets the run script of every environment
+	if e.App.KeepAlive {
+		return exitError(EXIT_CONFIG, fmt.Errorf("Keepalive can't be used to run several environments"))
+	}
+	if parallel > 1 && !e.App.UseDockerfile {
+		return exitError(EXIT_CONFIG, fmt.Errorf("Environments can't run in parallel with a shared folder"))
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	width := 0
+	for _, run := range runs {
+		if l := len(run.String()); l > width {
+			width = l
+		}
+	}
+
+	log.Infof("--> Running %d environments, %d at a time", len(runs), parallel)
+
+	errs := make([]error, len(runs))
+	summaries := make([]*RunSummary, len(runs))
+	reports := make([]*Report, len(runs))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for i, run := range runs {
+		slots <- struct{}{}
+
+		child, err := e.fork(run, fmt.Sprintf("%-*s |", width, run))
+		if err != nil {
+			<-slots
+			errs[i] = err
+			summaries[i] = NewRunSummary(e.App, run.env, time.Now(), err)
+			summaries[i].Variant = run.variant
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, run envRun, child *Engine) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			log.Infof("--> Starting environment %s", run)
+			errs[i] = child.runEnv(run.env)
+			summaries[i] = child.Summary
+			reports[i] = child.Docker.Report
+			log.Infof("--> Environment %s: %s", run, child.Summary.Status)
+		}(i, run, child)
+	}
+	wg.Wait()
+
+	e.Summaries = summaries
+	PrintSummaries(summaries)
+	PrintMatrix(summaries)
+
+	if e.App.Report != "" {
+		var written []*Report
+		for _, r := range reports {
+			if r != nil {
+				written = append(written, r)
+			}
+		}
+		if len(written) > 0 {
+			e.writeReports(written)
+		}
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return &ExitError{
+				Code: ExitCode(err),
+				Err:  fmt.Errorf("Environment %s: %s", runs[i], err),
+			}
+		}
+	}
+	return nil
+}
+
+// matrixRuns expands the environments with a matrix into
+// their variants, keeping the ones matching the matrix filter
+func (e *Engine) matrixRuns(envs []string) ([]envRun, error) {
+	var filter map[string]string
+	if e.App.MatrixFilter != "" {
+		var err error
+		filter, err = ParseMatrixFilter(e.App.MatrixFilter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Environments without a matrix are not filtered
+	var runs []envRun
+	matched := 0
+	for _, env := range envs {
+		m := e.App.GetMatrix(env)
+		if m == nil {
+			runs = append(runs, envRun{env: env})
+			continue
+		}
+
+		for _, v := range m.Variants() {
+			if filter != nil && !v.Match(filter) {
+				continue
+			}
+			runs = append(runs, envRun{env: env, variant: v})
+			matched++
+		}
+	}
+
+	if filter != nil && matched == 0 {
+		return nil, fmt.Errorf("No variant of the matrix matches %s", e.App.MatrixFilter)
+	}
+	return runs, nil
+}
+
+// fork returns an engine running the environment in its own
+// stack, with the output of the controller prefixed. No
+// engine is forked once the run has been interrupted
+func (e *Engine) fork(run envRun, prefix string) (*Engine, error) {
+	app, err := e.App.Clone()
+	if err != nil {
+		return nil, exitError(EXIT_CONFIG, err)
+	}
+	app.Variant = run.variant
+	return e.spawn(app, utils.NewStdPrefixed(prefix))
+}
+
+// spawn returns an engine running app in its own stack, it is
+// interrupted and killed along with e. The output of the
+// controller goes to out, prefixed by default if nil
+func (e *Engine) spawn(app *Application, out io.Writer) (*Engine, error) {
+	child := &Engine{
+		ClusterID: e.ClusterID,
+		Config:    e.Config,
+		App:       app,
+		Docker: &Docker{
+			Host:    e.Docker.Host,
+			Cert:    e.Docker.Cert,
+			Key:     e.Docker.Key,
+			CA:      e.Docker.CA,
+			Mode:    e.Docker.Mode,
+			Builder: &Builder{},
+			Output:  out,
+		},
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.interrupted {
+		return nil, &ExitError{Code: EXIT_INTERRUPTED, Err: fmt.Errorf("Run interrupted")}
+	}
+	e.children = append(e.children, child)
+
+	return child, nil
+}
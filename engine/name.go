@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 type ImageName struct {
@@ -16,6 +18,8 @@ type ImageName struct {
 
 const (
 	DOMAIN = ".skynet"
+	// Tag of the images built for a run
+	RUN_TAG = "stmp"
 )
 
 func (i *ImageName) GetAllNames() []string {
@@ -121,10 +125,17 @@ func GetNameFromApp(app *Application, mode int) ImageName {
 	}
 	// Run mode
 	if mode == RUN {
-		i.Tags = append(i.Tags, "stmp")
+		i.Tags = append(i.Tags, RUN_TAG)
 	}
-	// Git dependant tags
-	if app.Git != nil && mode == BUILD {
+	// Tags templates of the build replace the git ones,
+	// they have been checked by CheckBuildTags
+	if mode == BUILD && len(app.ActiveBuild.Tags) > 0 {
+		tags, err := app.ActiveBuild.RenderTags(app.GetTagData())
+		if err != nil {
+			log.Errorf("%s", err)
+		}
+		i.Tags = append(i.Tags, tags...)
+	} else if app.Git != nil && mode == BUILD {
 		re := regexp.MustCompile("//*")
 
 		if app.Git.Branch != "" {
@@ -140,9 +151,19 @@ func GetNameFromApp(app *Application, mode int) ImageName {
 			i.Tags = append(i.Tags, app.Git.Tag...)
 		}
 	}
+	latest := mode != BUILD || app.ActiveBuild.GetLatest()
+	// Semver builds float 1.4 and 1 to the release, and
+	// latest only moves to the highest release
+	if mode == BUILD && app.ActiveBuild.Semver {
+		tags, highest := SemverTags(app.Git)
+		i.Tags = mergeList(i.Tags, tags)
+		latest = latest && highest
+	}
 	// Since we're not using latest we need to set it each time,
 	// this way we can use the docker pull image global
-	i.Tags = append(i.Tags, "latest")
+	if latest {
+		i.Tags = append(i.Tags, "latest")
+	}
 	return i
 }
 
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// CreateNetwork creates the bridge network isolating the run,
+// the controller and services are attached to it
+func (d *Docker) CreateNetwork() error {
+	name := fmt.Sprintf("smg-%s-%s", d.App.getHostname(d.App.AppName), d.App.RunID)
+
+	network, err := d.Client.CreateNetwork(dockerclient.CreateNetworkOptions{
+		Name:           name,
+		Driver:         "bridge",
+		CheckDuplicate: true,
+		Options: map[string]interface{}{
+			LABEL_APP:     d.App.GetAppName(),
+			LABEL_RUN:     d.App.RunID,
+			LABEL_CREATED: strconv.FormatInt(time.Now().Unix(), 10),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Can't create network %s: %s", name, err)
+	}
+	log.Debugf("Network %s created", name)
+
+	d.lock.Lock()
+	d.Network = network
+	d.lock.Unlock()
+	return nil
+}
+
+// RemoveNetwork disconnects the protected containers
+// still running and removes the network of the run
+func (d *Docker) RemoveNetwork() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Network == nil {
+		return nil
+	}
+
+	for _, service := range d.Services {
+		if service.Protection && service.Docker != nil {
+			err := d.Client.DisconnectNetwork(d.Network.ID, dockerclient.NetworkConnectionOptions{
+				Container: service.Docker.ID,
+				Force:     true,
+			})
+			if err != nil {
+				log.Debugf("ERROR disconnecting %s, %s", service.Hostname, err)
+			}
+		}
+	}
+
+	err := d.Client.RemoveNetwork(d.Network.ID)
+	if err != nil {
+		return fmt.Errorf("Can't remove network %s: %s", d.Network.Name, err)
+	}
+	log.Debugf("Network %s removed", d.Network.Name)
+
+	d.Network = nil
+	return nil
+}
+
+// SetNetwork attaches the container to the network at
+// creation, its hostname is used as alias
+func (c *Container) SetNetwork(name string) {
+	if c.HostConfig == nil {
+		c.HostConfig = &dockerclient.HostConfig{}
+	}
+	c.HostConfig.NetworkMode = name
+	c.Network = name
+	c.NetworkingConfig = &dockerclient.NetworkingConfig{
+		EndpointsConfig: map[string]*dockerclient.EndpointConfig{
+			name: {Aliases: []string{c.Hostname}},
+		},
+	}
+}
+
+// ConnectNetwork attaches an already created container
+// to the network, its hostname is used as alias
+func (c *Container) ConnectNetwork(network *dockerclient.Network) error {
+	err := c.Client.ConnectNetwork(network.ID, dockerclient.NetworkConnectionOptions{
+		Container: c.Docker.ID,
+		EndpointConfig: &dockerclient.EndpointConfig{
+			Aliases: []string{c.Hostname},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Can't connect %s to the network: %s", c.Hostname, err)
+	}
+	c.Network = network.Name
+	return nil
+}
+
+// networkCreated returns the creation time of a network of smg,
+// the zero time for networks created without it
+func networkCreated(n *dockerclient.Network) time.Time {
+	created, err := strconv.ParseInt(n.Options[LABEL_CREATED], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(created, 0)
+}
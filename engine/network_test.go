@@ -0,0 +1,22 @@
+package engine
+
+import (
+	"testing"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+func TestRunNetworkInterrupted(t *testing.T) {
+	d := &Docker{Network: &dockerclient.Network{Name: "smg-app-0a1b2c3d"}}
+
+	network, err := d.runNetwork()
+	if err != nil || network.Name != "smg-app-0a1b2c3d" {
+		t.Errorf("Expected the network of the run, got %v %v", network, err)
+	}
+
+	// A signal during the setup stops the creation of containers
+	d.Interrupt(false)
+	if _, err := d.runNetwork(); ExitCode(err) != EXIT_INTERRUPTED {
+		t.Errorf("Expected an interrupted run, got %v", err)
+	}
+}
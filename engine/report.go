@@ -0,0 +1,350 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status of the commands in a report
+const (
+	REPORT_PASSED  = "passed"
+	REPORT_FAILED  = "failed"
+	REPORT_SKIPPED = "skipped"
+)
+
+// Formats of the report file, chosen by its extension
+const (
+	REPORT_JUNIT = "junit"
+	REPORT_JSON  = "json"
+)
+
+// Files the report is written to when
+// --report is given a format name
+var reportPaths = map[string]string{
+	REPORT_JUNIT: "report.xml",
+	REPORT_JSON:  "report.json",
+}
+
+// REPORT_MARKER prefixes the lines written by the run script
+// around each command, they are not shown in the output
+const REPORT_MARKER = "@@smg-report@@"
+
+// Report holds the result of each command of a run
+type Report struct {
+	App         string           `json:"app"`
+	Environment string           `json:"environment"`
+	Variant     string           `json:"variant,omitempty"`
+	RunID       string           `json:"run"`
+	Started     time.Time        `json:"started"`
+	Duration    float64          `json:"duration"`
+	ExitCode    int              `json:"exit_code"`
+	Commands    []*CommandResult `json:"commands"`
+
+	lock    sync.Mutex
+	current *CommandResult
+	output  bytes.Buffer
+	buf     bytes.Buffer
+	out     io.Writer
+}
+
+// CommandResult is the result of one command of the run,
+// its duration is in seconds
+type CommandResult struct {
+	Command  string  `json:"command"`
+	Status   string  `json:"status"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration"`
+	Output   string  `json:"output"`
+
+	started time.Time
+}
+
+// NewReport returns an empty report for the commands
+// of the active environment of the application
+func NewReport(a *Application) *Report {
+	r := &Report{
+		App:         a.GetAppName(),
+		Environment: a.Environment,
+		RunID:       a.RunID,
+		Started:     time.Now(),
+	}
+	if a.Variant != nil {
+		r.Variant = a.Variant.String()
+	}
+	for _, cmd := range a.Commands[a.Environment] {
+		r.Commands = append(r.Commands, &CommandResult{
+			Command: cmd,
+			Status:  REPORT_SKIPPED,
+		})
+	}
+	return r
+}
+
+// ReportPath returns the file a report is written to,
+// ex: --report json writes report.json
+func ReportPath(report string) string {
+	if path, ok := reportPaths[strings.ToLower(report)]; ok {
+		return path
+	}
+	return report
+}
+
+// ReportFormat returns the format of the report
+// written in path, based on its extension
+func ReportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		return REPORT_JUNIT, nil
+	case ".json":
+		return REPORT_JSON, nil
+	}
+	return "", fmt.Errorf("Unknown report format for %s, expecting a .xml (junit) or .json file", path)
+}
+
+// Writer returns a writer reading the markers of the run
+// script, every other line is copied to out
+func (r *Report) Writer(out io.Writer) io.Writer {
+	r.out = out
+	return r
+}
+
+// Write records the output of the current command and
+// the markers around them
+func (r *Report) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.buf.Write(p)
+	for {
+		line, err := r.buf.ReadString('\n')
+		if err == io.EOF {
+			// Keep the partial line for the next write
+			r.buf.WriteString(line)
+			break
+		}
+		// Output without a final newline ends on the marker line
+		if i := strings.Index(line, REPORT_MARKER); i >= 0 {
+			if i > 0 {
+				r.print(line[:i] + "\n")
+			}
+			r.marker(strings.Fields(line[i+len(REPORT_MARKER):]), time.Now())
+			continue
+		}
+		r.print(line)
+	}
+	return len(p), nil
+}
+
+func (r *Report) print(line string) {
+	if r.current != nil {
+		r.output.WriteString(line)
+	}
+	if r.out != nil {
+		r.out.Write([]byte(line))
+	}
+}
+
+// marker handles "start <index>" and "end <index> <code>"
+func (r *Report) marker(fields []string, now time.Time) {
+	if len(fields) < 2 {
+		return
+	}
+	i, err := strconv.Atoi(fields[1])
+	if err != nil || i < 0 || i >= len(r.Commands) {
+		return
+	}
+	cmd := r.Commands[i]
+
+	switch fields[0] {
+	case "start":
+		r.output.Reset()
+		cmd.started = now
+		r.current = cmd
+	case "end":
+		code := 0
+		if len(fields) > 2 {
+			code, _ = strconv.Atoi(fields[2])
+		}
+		r.end(cmd, code, now)
+	}
+}
+
+func (r *Report) end(cmd *CommandResult, code int, now time.Time) {
+	cmd.ExitCode = code
+	cmd.Status = REPORT_PASSED
+	if code != 0 {
+		cmd.Status = REPORT_FAILED
+	}
+	if !cmd.started.IsZero() {
+		cmd.Duration = now.Sub(cmd.started).Seconds()
+	}
+	if cmd == r.current {
+		cmd.Output = r.output.String()
+		r.output.Reset()
+		r.current = nil
+	}
+}
+
+// Finish closes the report with the exit code of the run,
+// a command left running failed with it
+func (r *Report) Finish(code int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	if r.buf.Len() > 0 {
+		r.print(r.buf.String() + "\n")
+		r.buf.Reset()
+	}
+	if r.current != nil {
+		failed := code
+		if failed == 0 {
+			failed = -1
+		}
+		r.end(r.current, failed, now)
+	}
+	r.ExitCode = code
+	r.Duration = now.Sub(r.Started).Seconds()
+}
+
+// WriteFile writes the report in path, as JUnit
+// or JSON depending on its extension
+func (r *Report) WriteFile(path string) error {
+	return WriteReports(path, []*Report{r})
+}
+
+// WriteReports writes the reports of several environments in
+// one file, a JUnit testsuite or a JSON object for each of them
+func WriteReports(path string, reports []*Report) error {
+	format, err := ReportFormat(path)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch {
+	case format == REPORT_JUNIT:
+		data, err = JUnit(reports)
+	case len(reports) == 1:
+		data, err = json.MarshalIndent(reports[0], "", "  ")
+	default:
+		data, err = json.MarshalIndent(reports, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// JUnit returns the report as a JUnit xml document,
+// with a testsuite for the environment and a testcase by command
+func (r *Report) JUnit() ([]byte, error) {
+	return JUnit([]*Report{r})
+}
+
+// JUnit returns the reports as a JUnit xml document
+// with a testsuite by environment
+func JUnit(reports []*Report) ([]byte, error) {
+	suites := junitSuites{}
+	for _, r := range reports {
+		suites.Suites = append(suites.Suites, r.junitSuite())
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func (r *Report) junitSuite() junitSuite {
+	name := r.App + "." + r.Environment
+	if r.Variant != "" {
+		name += "[" + r.Variant + "]"
+	}
+	suite := junitSuite{
+		Name:      name,
+		Tests:     len(r.Commands),
+		Time:      fmt.Sprintf("%.3f", r.Duration),
+		Timestamp: r.Started.Format(time.RFC3339),
+	}
+
+	for _, cmd := range r.Commands {
+		c := junitCase{
+			ClassName: name,
+			Name:      cmd.Command,
+			Time:      fmt.Sprintf("%.3f", cmd.Duration),
+		}
+		switch cmd.Status {
+		case REPORT_FAILED:
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("Exited with code %d", cmd.ExitCode),
+				Output:  cmd.Output,
+			}
+		case REPORT_SKIPPED:
+			suite.Skipped++
+			c.Skipped = &struct{}{}
+		default:
+			c.SystemOut = cmd.Output
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	return suite
+}
+
+// reportScript returns the run script executing the commands
+// one by one, each surrounded by markers read by the report
+func reportScript(lines []string) string {
+	var script bytes.Buffer
+
+	script.WriteString("__smg_step=-1\n")
+	// A failing command exits the script because of set -e,
+	// the trap closes it with its exit code
+	script.WriteString(fmt.Sprintf("trap '__smg_code=$?; if [ $__smg_step -ge 0 ]; then echo \"%s end $__smg_step $__smg_code\"; fi' EXIT\n", REPORT_MARKER))
+	for i, line := range lines {
+		script.WriteString(fmt.Sprintf("__smg_step=%d; echo \"%s start %d\"\n", i, REPORT_MARKER, i))
+		script.WriteString(fmt.Sprintf("%s\n", line))
+		script.WriteString(fmt.Sprintf("echo \"%s end %d 0\"; __smg_step=-1\n", REPORT_MARKER, i))
+	}
+	return script.String()
+}
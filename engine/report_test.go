@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	a := &Application{
+		Name:        "smuggler",
+		Environment: "test",
+		Commands: map[string][]string{
+			"test": {"echo build", "printf partial", "echo failing; exit 3", "echo never"},
+		},
+	}
+	r := NewReport(a)
+
+	var out bytes.Buffer
+	w := r.Writer(&out)
+	w.Write([]byte(REPORT_MARKER + " start 0\nbuild\n" + REPORT_MARKER + " end 0 0\n"))
+	w.Write([]byte(REPORT_MARKER + " start 1\npart"))
+	w.Write([]byte("ial" + REPORT_MARKER + " end 1 0\n"))
+	w.Write([]byte(REPORT_MARKER + " start 2\nfailing\n" + REPORT_MARKER + " end 2 3\n"))
+	r.Finish(3)
+
+	if out.String() != "build\npartial\nfailing\n" {
+		t.Errorf("Markers should be hidden from the output, got %q", out.String())
+	}
+
+	expected := []struct {
+		status string
+		code   int
+		output string
+	}{
+		{REPORT_PASSED, 0, "build\n"},
+		{REPORT_PASSED, 0, "partial\n"},
+		{REPORT_FAILED, 3, "failing\n"},
+		{REPORT_SKIPPED, 0, ""},
+	}
+	for i, e := range expected {
+		c := r.Commands[i]
+		if c.Status != e.status || c.ExitCode != e.code || c.Output != e.output {
+			t.Errorf("Command %d expected %s %d %q, got %s %d %q", i, e.status, e.code, e.output, c.Status, c.ExitCode, c.Output)
+		}
+	}
+	if r.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", r.ExitCode)
+	}
+
+	data, err := r.JUnit()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var suites junitSuites
+	err = xml.Unmarshal(data, &suites)
+	if err != nil {
+		t.Fatalf("Invalid junit: %s", err)
+	}
+	s := suites.Suites[0]
+	if s.Name != "smuggler.test" || s.Tests != 4 || s.Failures != 1 || s.Skipped != 1 {
+		t.Errorf("Unexpected suite %s tests=%d failures=%d skipped=%d", s.Name, s.Tests, s.Failures, s.Skipped)
+	}
+}
+
+func TestReportUnfinished(t *testing.T) {
+	a := &Application{
+		Environment: "test",
+		Commands:    map[string][]string{"test": {"sleep 100"}},
+	}
+	r := NewReport(a)
+	r.Writer(nil).Write([]byte(REPORT_MARKER + " start 0\nsleeping"))
+	r.Finish(137)
+
+	c := r.Commands[0]
+	if c.Status != REPORT_FAILED || c.ExitCode != 137 || c.Output != "sleeping\n" {
+		t.Errorf("Killed command expected failed 137, got %s %d %q", c.Status, c.ExitCode, c.Output)
+	}
+}
+
+func TestReportScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	lines := []string{"cd /", "pwd", "false", "echo never"}
+	a := &Application{
+		Environment: "test",
+		Commands:    map[string][]string{"test": lines},
+	}
+	r := NewReport(a)
+
+	cmd := exec.Command(bash, "-c", "set -e\n"+reportScript(lines))
+	cmd.Stdout = r.Writer(nil)
+	err = cmd.Run()
+	if err == nil {
+		t.Errorf("Script should fail with the failing command")
+	}
+	r.Finish(1)
+
+	statuses := []string{}
+	for _, c := range r.Commands {
+		statuses = append(statuses, c.Status)
+	}
+	if strings.Join(statuses, " ") != "passed passed failed skipped" {
+		t.Errorf("Unexpected statuses %v", statuses)
+	}
+	if r.Commands[1].Output != "/\n" {
+		t.Errorf("Commands should share the shell, got %q", r.Commands[1].Output)
+	}
+	if r.Commands[2].ExitCode != 1 {
+		t.Errorf("Expected exit code 1, got %d", r.Commands[2].ExitCode)
+	}
+}
+
+func TestReportFormat(t *testing.T) {
+	for path, format := range map[string]string{"junit.xml": REPORT_JUNIT, "out/report.JSON": REPORT_JSON} {
+		f, err := ReportFormat(path)
+		if err != nil || f != format {
+			t.Errorf("Expected %s for %s, got %s %v", format, path, f, err)
+		}
+	}
+	if _, err := ReportFormat("report.txt"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestReportPath(t *testing.T) {
+	paths := map[string]string{
+		"json":          "report.json",
+		"JUnit":         "report.xml",
+		"out/tests.xml": "out/tests.xml",
+		"":              "",
+	}
+	for report, expected := range paths {
+		path := ReportPath(report)
+		if path != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, report, path)
+		}
+		if _, err := ReportFormat(path); report != "" && err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smg-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reports := []*Report{
+		{App: "smuggler", Environment: "unit", Commands: []*CommandResult{{Command: "make test", Status: REPORT_PASSED}}},
+		{App: "smuggler", Environment: "lint", Commands: []*CommandResult{{Command: "make lint", Status: REPORT_FAILED, ExitCode: 2}}},
+	}
+
+	path := filepath.Join(dir, "junit.xml")
+	if err := WriteReports(path, reports); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	var suites junitSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if len(suites.Suites) != 2 || suites.Suites[1].Name != "smuggler.lint" || suites.Suites[1].Failures != 1 {
+		t.Errorf("Expected a testsuite by environment, got %+v", suites.Suites)
+	}
+
+	path = filepath.Join(dir, "report.json")
+	if err := WriteReports(path, reports); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(path)
+	var decoded []*Report
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded) != 2 {
+		t.Errorf("Expected an array of reports, got %s %v", data, err)
+	}
+
+	// A single environment keeps the report as an object
+	if err := reports[0].WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(path)
+	var single Report
+	if err := json.Unmarshal(data, &single); err != nil || single.Environment != "unit" {
+		t.Errorf("Expected a single report, got %s %v", data, err)
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/jbdalido/smg/utils"
+)
+
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Semver is a semantic version read from a git tag, ex: v1.4.2-rc1
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// ParseSemver parses the version of a git tag, the v prefix is optional
+func ParseSemver(tag string) (*Semver, error) {
+	m := semverRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a semantic version", tag)
+	}
+
+	v := &Semver{Prerelease: m[4]}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	return v, nil
+}
+
+// String returns the version without the v prefix
+func (v *Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// firstSemver returns the first of the tags that
+// is a semantic version, or nil if there is none
+func firstSemver(tags []string) *Semver {
+	for _, tag := range tags {
+		if v, err := ParseSemver(tag); err == nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or higher
+// than o, a pre-release is lower than its release
+func (v *Semver) Compare(o *Semver) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	case v.Prerelease < o.Prerelease:
+		return -1
+	}
+	return 1
+}
+
+// SemverTags returns the floating tags of the release tagged on the
+// last commit, 1.4 and 1 for v1.4.2, each one only if no higher release
+// of the repository floats under it. highest tells if the release is
+// the highest of the repository, the only one latest can move to.
+// Pre-releases don't get any floating tag
+func SemverTags(g *utils.Git) (tags []string, highest bool) {
+	if g == nil {
+		return nil, false
+	}
+
+	var v *Semver
+	for _, tag := range g.Tag {
+		s, err := ParseSemver(tag)
+		if err != nil || s.Prerelease != "" {
+			continue
+		}
+		if v == nil || s.Compare(v) > 0 {
+			v = s
+		}
+	}
+	if v == nil {
+		return nil, false
+	}
+
+	minor, major, highest := true, true, true
+	for _, tag := range g.AllTags {
+		s, err := ParseSemver(tag)
+		if err != nil || s.Prerelease != "" || s.Compare(v) <= 0 {
+			continue
+		}
+		highest = false
+		if s.Major == v.Major {
+			major = false
+			if s.Minor == v.Minor {
+				minor = false
+			}
+		}
+	}
+
+	if minor {
+		tags = append(tags, fmt.Sprintf("%d.%d", v.Major, v.Minor))
+	}
+	if major {
+		tags = append(tags, fmt.Sprintf("%d", v.Major))
+	}
+	return tags, highest
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jbdalido/smg/utils"
+)
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.4.2", "1.4.2", 0},
+		{"1.4.2", "1.4.10", -1},
+		{"2.0.0", "1.9.9", 1},
+		{"1.5.0-rc1", "1.5.0", -1},
+		{"1.5.0-rc2", "1.5.0-rc1", 1},
+	}
+	for _, test := range tests {
+		a, _ := ParseSemver(test.a)
+		b, _ := ParseSemver(test.b)
+		if cmp := a.Compare(b); cmp != test.cmp {
+			t.Errorf("Expected %d comparing %s to %s, got %d", test.cmp, test.a, test.b, cmp)
+		}
+	}
+}
+
+func TestSemverTags(t *testing.T) {
+	all := []string{"v1.3.0", "v1.4.1", "v1.4.2", "v1.3.5", "v2.0.0-rc1", "release-2016", "v0.9.0"}
+	tests := []struct {
+		head    []string
+		tags    []string
+		highest bool
+	}{
+		// The highest release floats everything
+		{[]string{"v1.4.2"}, []string{"1.4", "1"}, true},
+		// A patch of an older minor only floats its minor
+		{[]string{"v1.3.5"}, []string{"1.3"}, false},
+		// An older patch doesn't float anything
+		{[]string{"v1.4.1"}, nil, false},
+		{[]string{"v0.9.0"}, []string{"0.9", "0"}, false},
+		// Pre-releases don't touch the floating tags
+		{[]string{"v2.0.0-rc1"}, nil, false},
+		{[]string{"release-2016"}, nil, false},
+		{nil, nil, false},
+	}
+	for _, test := range tests {
+		tags, highest := SemverTags(&utils.Git{Tag: test.head, AllTags: all})
+		if !reflect.DeepEqual(tags, test.tags) || highest != test.highest {
+			t.Errorf("Expected %v %v for %v, got %v %v", test.tags, test.highest, test.head, tags, highest)
+		}
+	}
+
+	if tags, highest := SemverTags(nil); tags != nil || highest {
+		t.Errorf("Expected no tags without git")
+	}
+}
+
+func TestGetNameFromAppSemver(t *testing.T) {
+	a := &Application{
+		Name:          "smuggler",
+		UseDockerfile: true,
+		Git: &utils.Git{
+			Branch:     "master",
+			LastCommit: &utils.Commit{ID: "0123456789abcdef", Short: "012345678"},
+			Tag:        []string{"v1.3.5"},
+			AllTags:    []string{"v1.3.5", "v1.4.2"},
+		},
+		ActiveBuild: &Build{Name: "org/api", Semver: true},
+	}
+
+	// latest stays on v1.4.2
+	i := GetNameFromApp(a, BUILD)
+	if !reflect.DeepEqual(i.Tags, []string{"master", "012345678", "v1.3.5", "1.3"}) {
+		t.Errorf("Expected the minor without latest, got %v", i.Tags)
+	}
+
+	a.Git.Tag = []string{"v1.4.3"}
+	a.Git.AllTags = append(a.Git.AllTags, "v1.4.3")
+	i = GetNameFromApp(a, BUILD)
+	if !reflect.DeepEqual(i.Tags, []string{"master", "012345678", "v1.4.3", "1.4", "1", "latest"}) {
+		t.Errorf("Expected the floating tags and latest, got %v", i.Tags)
+	}
+}
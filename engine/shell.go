@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
+)
+
+// Shell sets up the run environment like Run does, but
+// attaches the terminal to an interactive shell instead
+// of running the commands
+func (e *Engine) Shell(env string, shell []string) error {
+
+	if len(shell) == 0 {
+		return fmt.Errorf("No shell to start")
+	}
+
+	err := e.Docker.Connect()
+	if err != nil {
+		return exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
+	}
+
+	err = e.App.SetEnv(env)
+	if err != nil {
+		return fmt.Errorf("%s", err)
+	}
+
+	err = e.Docker.Configure(e.App, RUN)
+	if err != nil {
+		return err
+	}
+	e.Docker.Shell = shell
+
+	// defer the stop and delete of the containers
+	// and network, even if the setup fails
+	defer e.Stop()
+
+	err = e.Docker.SetupContainers()
+	if err != nil {
+		return err
+	}
+
+	err = e.Docker.StartServices()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("--> Starting %s in %s ...", shell[0], e.Docker.Controller.Image.ToString())
+
+	code, err := e.Docker.Controller.StartInteractive(os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	log.Debugf("--> Shell exited with code %d", code)
+
+	return nil
+}
+
+// StartInteractive starts the container with the terminal
+// attached in raw mode, following its size
+func (c *Container) StartInteractive(in *os.File, out io.Writer) (int, error) {
+
+	tty := utils.IsTerminal(in.Fd())
+	if tty {
+		state, err := utils.MakeRaw(in.Fd())
+		if err != nil {
+			return -1, err
+		}
+		defer utils.RestoreTerminal(in.Fd(), state)
+	}
+
+	// Attach before starting so we don't miss anything
+	attach, err := c.Client.AttachToContainerNonBlocking(dockerclient.AttachToContainerOptions{
+		Container:    c.Docker.ID,
+		InputStream:  in,
+		OutputStream: out,
+		ErrorStream:  out,
+		Stream:       true,
+		Stdin:        true,
+		Stdout:       true,
+		Stderr:       true,
+		RawTerminal:  true,
+	})
+	if err != nil {
+		return -1, err
+	}
+	defer attach.Close()
+
+	err = c.Client.StartContainer(c.Docker.ID, c.HostConfig)
+	if err != nil {
+		return -1, err
+	}
+
+	if tty {
+		c.resize(in.Fd())
+		resize := make(chan os.Signal, 1)
+		utils.NotifyResize(resize)
+		go func() {
+			for range resize {
+				c.resize(in.Fd())
+			}
+		}()
+	}
+
+	err = attach.Wait()
+	if err != nil {
+		return -1, err
+	}
+
+	c.Code, err = c.Client.WaitContainer(c.Docker.ID)
+	if err != nil {
+		return -1, err
+	}
+	return c.Code, nil
+}
+
+// resize sets the container tty to the terminal size
+func (c *Container) resize(fd uintptr) {
+	height, width, err := utils.GetWinsize(fd)
+	if err != nil || height == 0 || width == 0 {
+		return
+	}
+	err = c.Client.ResizeContainerTTY(c.Docker.ID, height, width)
+	if err != nil {
+		log.Debugf("ERROR resizing %s, %s", c.Hostname, err)
+	}
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerStatus describes a container created by smuggler
+type ContainerStatus struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Image   string    `json:"image"`
+	App     string    `json:"app"`
+	Env     string    `json:"env"`
+	RunID   string    `json:"run_id"`
+	Role    string    `json:"role"`
+	Branch  string    `json:"branch"`
+	Commit  string    `json:"commit"`
+	State   string    `json:"state"`
+	Status  string    `json:"status"`
+	Ports   []string  `json:"ports"`
+	Created time.Time `json:"created"`
+}
+
+// Ps lists the containers created by smuggler, running ones
+// only unless all is set, app restricts them to an application
+func (e *Engine) Ps(all bool, app string) ([]ContainerStatus, error) {
+
+	err := e.Docker.Connect()
+	if err != nil {
+		return nil, exitError(EXIT_DOCKER, fmt.Errorf("Could not connect to the Docker %s", err))
+	}
+
+	label := LABEL_APP
+	if app != "" {
+		label = LABEL_APP + "=" + app
+	}
+
+	containers, err := e.Docker.ListContainers(all, label)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []ContainerStatus
+	for _, c := range containers {
+		s := ContainerStatus{
+			ID:      c.ID[:12],
+			Image:   c.Image,
+			App:     c.Labels[LABEL_APP],
+			Env:     c.Labels[LABEL_ENV],
+			RunID:   c.Labels[LABEL_RUN],
+			Role:    c.Labels[LABEL_ROLE],
+			Branch:  c.Labels[LABEL_BRANCH],
+			Commit:  c.Labels[LABEL_COMMIT],
+			State:   c.State,
+			Status:  c.Status,
+			Ports:   formatPorts(c.Ports),
+			Created: time.Unix(c.Created, 0),
+		}
+		if len(c.Names) > 0 {
+			s.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		status = append(status, s)
+	}
+
+	// Group the containers by run, controllers first
+	sort.Sort(newByRun(status))
+
+	return status, nil
+}
+
+func formatPorts(ports []dockerclient.APIPort) []string {
+	var formatted []string
+	for _, p := range ports {
+		if p.PublicPort != 0 {
+			formatted = append(formatted, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+			continue
+		}
+		formatted = append(formatted, fmt.Sprintf("%d/%s", p.PrivatePort, p.Type))
+	}
+	return formatted
+}
+
+// byRun sorts containers by run, latest runs first
+type byRun struct {
+	status  []ContainerStatus
+	started map[string]time.Time
+}
+
+func newByRun(status []ContainerStatus) byRun {
+	started := make(map[string]time.Time)
+	for _, s := range status {
+		if t, ok := started[s.RunID]; !ok || s.Created.Before(t) {
+			started[s.RunID] = s.Created
+		}
+	}
+	return byRun{status: status, started: started}
+}
+
+func (s byRun) Len() int      { return len(s.status) }
+func (s byRun) Swap(i, j int) { s.status[i], s.status[j] = s.status[j], s.status[i] }
+func (s byRun) Less(i, j int) bool {
+	a, b := s.status[i], s.status[j]
+	if a.RunID != b.RunID {
+		if !s.started[a.RunID].Equal(s.started[b.RunID]) {
+			return s.started[a.RunID].After(s.started[b.RunID])
+		}
+		return a.RunID < b.RunID
+	}
+	if a.Role != b.Role {
+		return a.Role == ROLE_CONTROLLER
+	}
+	return a.Name < b.Name
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Status of a run in its summary
+const (
+	STATUS_SUCCESS = "success"
+	STATUS_FAILED  = "failed"  // The controller exited with a non-zero code
+	STATUS_TIMEOUT = "timeout" // The run lasted longer than its timeout
+	STATUS_STOPPED = "stopped" // The run has been interrupted by a signal
+	STATUS_ERROR   = "error"   // smg failed to setup or run the environment
+)
+
+// RunSummary sums up a run, so that a failure of the
+// tests can be told from a failure of smg
+type RunSummary struct {
+	App         string         `json:"app"`
+	Environment string         `json:"environment"`
+	Variant     *MatrixVariant `json:"variant,omitempty"`
+	RunID       string         `json:"run"`
+	Status      string         `json:"status"`
+	ExitCode    int            `json:"exit_code"`
+	Duration    time.Duration  `json:"duration"`
+	Error       string         `json:"error,omitempty"`
+	Passed      int            `json:"passed,omitempty"`
+	Failed      int            `json:"failed,omitempty"`
+	Skipped     int            `json:"skipped,omitempty"`
+}
+
+// NewRunSummary returns the summary of the run
+// of env started at started, ended by err
+func NewRunSummary(a *Application, env string, started time.Time, err error) *RunSummary {
+	s := &RunSummary{
+		App:         a.GetAppName(),
+		Environment: env,
+		Variant:     a.Variant,
+		RunID:       a.RunID,
+		Status:      STATUS_SUCCESS,
+		ExitCode:    ExitCode(err),
+		Duration:    time.Since(started),
+	}
+
+	switch err.(type) {
+	case nil:
+	case *RunError:
+		s.Status = STATUS_FAILED
+	case *TimeoutError:
+		s.Status = STATUS_TIMEOUT
+		s.Error = err.Error()
+	default:
+		if s.ExitCode == EXIT_INTERRUPTED {
+			s.Status = STATUS_STOPPED
+			break
+		}
+		s.Status = STATUS_ERROR
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// SetReport counts the commands of the report by status
+func (s *RunSummary) SetReport(r *Report) {
+	s.Passed, s.Failed, s.Skipped = 0, 0, 0
+	for _, c := range r.Commands {
+		switch c.Status {
+		case REPORT_PASSED:
+			s.Passed++
+		case REPORT_FAILED:
+			s.Failed++
+		case REPORT_SKIPPED:
+			s.Skipped++
+		}
+	}
+}
+
+// Print logs the summary, one field per line
+func (s *RunSummary) Print() {
+	log.Infof("--> Summary")
+	log.Infof("    app:          %s", s.App)
+	log.Infof("    environment:  %s", s.Environment)
+	if s.RunID != "" {
+		log.Infof("    run:          %s", s.RunID)
+	}
+	log.Infof("    status:       %s", s.Status)
+	log.Infof("    exit code:    %d", s.ExitCode)
+	log.Infof("    duration:     %s", s.Duration.Round(time.Millisecond))
+	if s.Passed+s.Failed+s.Skipped > 0 {
+		log.Infof("    commands:     %d passed, %d failed, %d skipped", s.Passed, s.Failed, s.Skipped)
+	}
+	if s.Error != "" {
+		log.Infof("    error:        %s", s.Error)
+	}
+}
+
+// PrintSummaries logs the summaries of several runs as
+// a table, one environment or variant of the matrix per line
+func PrintSummaries(summaries []*RunSummary) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tRUN\tSTATUS\tEXIT CODE\tDURATION\tCOMMANDS\tERROR")
+	failed := 0
+	for _, s := range summaries {
+		if s.ExitCode != EXIT_SUCCESS {
+			failed++
+		}
+		commands := ""
+		if s.Passed+s.Failed+s.Skipped > 0 {
+			commands = fmt.Sprintf("%d passed, %d failed, %d skipped", s.Passed, s.Failed, s.Skipped)
+		}
+		env := s.Environment
+		if s.Variant != nil {
+			env += " " + s.Variant.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", env, s.RunID, s.Status,
+			s.ExitCode, s.Duration.Round(time.Millisecond), commands, s.Error)
+	}
+	w.Flush()
+
+	log.Infof("--> Summary: %d of %d runs failed", failed, len(summaries))
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Infof("    %s", line)
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TAG_MAX_LENGTH is the longest tag accepted by docker
+const TAG_MAX_LENGTH = 128
+
+var invalidTagRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// TagData is given to the tag templates of a build,
+// ex: {{.Branch}}-{{.ShortCommit}} or {{.Semver.Major}}
+type TagData struct {
+	Branch      string
+	Commit      string
+	ShortCommit string
+	// Tag is the first git tag of the commit
+	Tag string
+	// Date is the day of the build, as 20060102,
+	// Time can be formatted with {{.Time.Format "..."}}
+	Date string
+	Time time.Time
+	// Semver is the first git tag of the commit that is
+	// a semantic version, nil if there is none
+	Semver *Semver
+}
+
+// NewTagData returns the data of the tag templates
+// from the git repository of the application
+func NewTagData(a *Application) *TagData {
+	now := time.Now().UTC()
+	data := &TagData{
+		Date: now.Format("20060102"),
+		Time: now,
+	}
+	if a.Git != nil {
+		data.Branch = a.Git.Branch
+		if a.Git.LastCommit != nil {
+			data.Commit = a.Git.LastCommit.ID
+			data.ShortCommit = a.Git.LastCommit.Short
+		}
+		if len(a.Git.Tag) > 0 {
+			data.Tag = a.Git.Tag[0]
+		}
+		data.Semver = firstSemver(a.Git.Tag)
+	}
+	return data
+}
+
+// GetTagData returns the data of the tag templates of the
+// build, {{.Time}} is the same for every image and push
+func (a *Application) GetTagData() *TagData {
+	if a.tagData == nil {
+		a.tagData = NewTagData(a)
+	}
+	return a.tagData
+}
+
+// CheckBuildTags makes sure the images of the active build
+// get at least one tag, and that its templates are valid
+func CheckBuildTags(a *Application, tag string) error {
+	_, err := a.ActiveBuild.RenderTags(a.GetTagData())
+	if err != nil {
+		return err
+	}
+	if name := GetNameFromAppWithTag(a, tag, BUILD); len(name.Tags) == 0 {
+		return fmt.Errorf("The images of build %s have no tag, its tags render empty and latest is disabled", a.BuildTarget)
+	}
+	return nil
+}
+
+var tagFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// RenderTags renders the tag templates of the build, templates
+// that can't be rendered with this data, like {{.Semver.Major}}
+// without a version tag, or that render empty are left out
+func (b *Build) RenderTags(data *TagData) ([]string, error) {
+	var tags []string
+	for _, text := range b.Tags {
+		t, err := template.New("tag").Funcs(tagFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tag template %s: %s", text, err)
+		}
+
+		// With every field set, only the errors
+		// of the template itself are left
+		err = t.Execute(ioutil.Discard, &TagData{Semver: &Semver{}})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tag template %s: %s", text, err)
+		}
+
+		var buf bytes.Buffer
+		err = t.Execute(&buf, data)
+		if err != nil {
+			continue
+		}
+
+		tag := SanitizeTag(buf.String())
+		if tag != "" {
+			tags = mergeList(tags, []string{tag})
+		}
+	}
+	return tags, nil
+}
+
+// GetLatest tells if the images of the build are tagged latest
+func (b *Build) GetLatest() bool {
+	return b.Latest == nil || *b.Latest
+}
+
+// SanitizeTag turns s into a valid docker tag, slashes
+// become dots like for branches and any other invalid
+// character becomes a dash
+func SanitizeTag(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.Replace(s, "/", ".", -1)
+	s = invalidTagRegexp.ReplaceAllString(s, "-")
+	// Tags can't start with a dot or a dash
+	s = strings.TrimLeft(s, ".-")
+	if len(s) > TAG_MAX_LENGTH {
+		s = s[:TAG_MAX_LENGTH]
+	}
+	return s
+}
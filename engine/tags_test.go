@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbdalido/smg/utils"
+	"gopkg.in/yaml.v1"
+)
+
+func TestRenderTags(t *testing.T) {
+	os.Setenv("SMG_TEST_BUILD", "42")
+	defer os.Unsetenv("SMG_TEST_BUILD")
+
+	data := &TagData{
+		Branch:      "feature/api",
+		ShortCommit: "012345678",
+		Date:        "20170301",
+		Time:        time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC),
+		Semver:      &Semver{Major: 1, Minor: 4, Patch: 2},
+	}
+	b := &Build{Tags: []string{
+		"{{.Branch}}-{{.ShortCommit}}",
+		"{{.Date}}",
+		"{{.Semver.Major}}.{{.Semver.Minor}}",
+		`build-{{env "SMG_TEST_BUILD"}}`,
+		`{{env "SMG_TEST_UNSET"}}`,
+		`{{.Time.Format "2006-01"}}`,
+		"{{.Date}}",
+	}}
+
+	tags, err := b.RenderTags(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"feature.api-012345678", "20170301", "1.4", "build-42", "2017-03"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	// Without a version tag, semver templates are left out
+	data.Semver = nil
+	tags, err = b.RenderTags(data)
+	if err != nil || len(tags) != 4 {
+		t.Errorf("Expected the semver tag to be left out, got %v %v", tags, err)
+	}
+
+	for _, text := range []string{"{{.Branch", "{{.Unknown}}", `{{nope "x"}}`} {
+		b := &Build{Tags: []string{text}}
+		if _, err := b.RenderTags(data); err == nil {
+			t.Errorf("Expected an error for %s", text)
+		}
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	tags := map[string]string{
+		"feature/api":    "feature.api",
+		"fix #12: ok":    "fix--12--ok",
+		"-.v1.0":         "v1.0",
+		" 1.4 ":          "1.4",
+		"release_2017Q1": "release_2017Q1",
+	}
+	for s, expected := range tags {
+		if tag := SanitizeTag(s); tag != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, s, tag)
+		}
+	}
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if tag := SanitizeTag(string(long)); len(tag) != TAG_MAX_LENGTH {
+		t.Errorf("Expected a tag of %d characters, got %d", TAG_MAX_LENGTH, len(tag))
+	}
+}
+
+func TestGetNameFromAppTags(t *testing.T) {
+	var b Build
+	err := yaml.Unmarshal([]byte("name: org/api\ntags:\n  - \"{{.Branch}}\"\nlatest: false\n"), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Application{
+		Name:          "smuggler",
+		UseDockerfile: true,
+		Git: &utils.Git{
+			Branch:     "master",
+			LastCommit: &utils.Commit{ID: "0123456789abcdef", Short: "012345678"},
+			Tag:        []string{"v1.4.2"},
+		},
+		ActiveBuild: &b,
+	}
+
+	i := GetNameFromAppWithTag(a, "ci", BUILD)
+	if !reflect.DeepEqual(i.Tags, []string{"master", "ci"}) {
+		t.Errorf("Expected the templates without latest, got %v", i.Tags)
+	}
+
+	// Without templates the git tags are used
+	a.ActiveBuild = &Build{Name: "org/api"}
+	i = GetNameFromApp(a, BUILD)
+	if !reflect.DeepEqual(i.Tags, []string{"master", "012345678", "v1.4.2", "latest"}) {
+		t.Errorf("Expected the git tags, got %v", i.Tags)
+	}
+}
+
+func TestCheckBuildTags(t *testing.T) {
+	latest := false
+	a := &Application{
+		Name:          "smuggler",
+		UseDockerfile: true,
+		BuildTarget:   "master",
+		Git: &utils.Git{
+			Branch:     "master",
+			LastCommit: &utils.Commit{ID: "0123456789abcdef", Short: "012345678"},
+		},
+		ActiveBuild: &Build{
+			Name:   "org/api",
+			Tags:   []string{"{{.Semver.Major}}", `{{env "SMG_TEST_UNSET"}}`},
+			Latest: &latest,
+		},
+	}
+
+	if err := CheckBuildTags(a, ""); err == nil {
+		t.Errorf("Expected an error for a build without tags")
+	}
+	if err := CheckBuildTags(a, "ci"); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	a.ActiveBuild.Tags = []string{"{{.Unknown}}"}
+	if err := CheckBuildTags(a, "ci"); err == nil {
+		t.Errorf("Expected an error for an invalid template")
+	}
+
+	// The time of the tags doesn't move between images
+	if a.GetTagData() != a.GetTagData() {
+		t.Errorf("Tag data computed twice")
+	}
+}
+
+func TestParseSemver(t *testing.T) {
+	tests := map[string]string{
+		"v1.4.2":         "1.4.2",
+		"1.4.2-rc1":      "1.4.2-rc1",
+		"v2.0.0+build.5": "2.0.0",
+	}
+	for tag, expected := range tests {
+		v, err := ParseSemver(tag)
+		if err != nil || v.String() != expected {
+			t.Errorf("Expected %s for %s, got %v %v", expected, tag, v, err)
+		}
+	}
+	for _, tag := range []string{"1.4", "v01.2.3", "release"} {
+		if _, err := ParseSemver(tag); err == nil {
+			t.Errorf("Expected an error for %s", tag)
+		}
+	}
+}
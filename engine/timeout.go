@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/jbdalido/smg/utils"
+)
+
+// EXIT_TIMEOUT is the exit code of smg when
+// the run is stopped by its timeout
+const EXIT_TIMEOUT = 124
+
+// TIMEOUT_TAIL is the number of log lines
+// shown for each container after a timeout
+const TIMEOUT_TAIL = "50"
+
+// TimeoutError is returned when the controller
+// runs for longer than its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Run timed out after %s", e.Timeout)
+}
+
+// ExitCode is the exit code of smg after a timeout
+func (e *TimeoutError) ExitCode() int {
+	return EXIT_TIMEOUT
+}
+
+// GetTimeout returns the timeout of the run,
+// the --timeout flag wins over the smuggler file
+func (a *Application) GetTimeout() time.Duration {
+	if a.ForceTimeout > 0 {
+		return a.ForceTimeout
+	}
+	return a.Timeout
+}
+
+// CheckDurations rejects timeouts and healthcheck durations under
+// a second, yaml reads a number without unit as nanoseconds
+func (a *Application) CheckDurations() error {
+	if err := checkDuration("timeout", a.Timeout); err != nil {
+		return err
+	}
+	if err := a.HealthCheck.checkDurations(a.Name); err != nil {
+		return err
+	}
+	for name, app := range a.Applications {
+		if app == nil {
+			continue
+		}
+		if err := app.HealthCheck.checkDurations(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HealthCheck) checkDurations(name string) error {
+	if h == nil {
+		return nil
+	}
+	if err := checkDuration("healthcheck interval of "+name, h.Interval); err != nil {
+		return err
+	}
+	return checkDuration("healthcheck timeout of "+name, h.Timeout)
+}
+
+func checkDuration(field string, d time.Duration) error {
+	if d != 0 && d < time.Second {
+		return fmt.Errorf("Invalid %s %s, durations need a unit (ex: 30s)", field, d)
+	}
+	return nil
+}
+
+// Wait waits for the container to exit, for
+// at most timeout if it is not zero
+func (c *Container) Wait(timeout time.Duration) (int, error) {
+	if timeout <= 0 {
+		return c.Client.WaitContainer(c.Docker.ID)
+	}
+
+	type result struct {
+		code int
+		err  error
+	}
+	wait := make(chan result, 1)
+	go func() {
+		code, err := c.Client.WaitContainer(c.Docker.ID)
+		wait <- result{code, err}
+	}()
+
+	select {
+	case r := <-wait:
+		return r.code, r.err
+	case <-time.After(timeout):
+		return -1, &TimeoutError{Timeout: timeout}
+	}
+}
+
+// Timeout shows the last lines of the logs of each
+// container, then kills the controller and stops the services
+func (d *Docker) Timeout() {
+
+	containers := append([]*Container{d.Controller}, d.Services...)
+	width := 0
+	for _, c := range containers {
+		if l := len(c.Hostname); l > width {
+			width = l
+		}
+	}
+
+	for _, c := range containers {
+		if c == nil || c.Docker == nil {
+			continue
+		}
+		log.Infof("--> Last %s lines of %s", TIMEOUT_TAIL, c.Hostname)
+		out := utils.NewStdPrefixed(fmt.Sprintf("%-*s |", width, c.Hostname))
+		err := d.Client.Logs(dockerclient.LogsOptions{
+			Container:    c.Docker.ID,
+			OutputStream: out,
+			ErrorStream:  out,
+			Stdout:       true,
+			Stderr:       true,
+			Tail:         TIMEOUT_TAIL,
+		})
+		out.Flush()
+		if err != nil {
+			log.Debugf("ERROR getting logs of %s, %s", c.Hostname, err)
+		}
+	}
+
+	log.Infof("--> Killing %s and stopping services", d.Controller.Hostname)
+	if d.Controller.Docker != nil && !d.Controller.Protection {
+		err := d.Client.KillContainer(dockerclient.KillContainerOptions{ID: d.Controller.Docker.ID})
+		if err != nil {
+			log.Errorf("Error - Cannot kill %s: %s", d.Controller.Hostname, err)
+		}
+	}
+	for _, service := range d.Services {
+		service.StopTimeout = d.App.StopTimeout
+		err := service.Stop()
+		if err != nil {
+			log.Errorf("Error - Cannot stop %s", service.ID)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/docker/go-units"
 	"github.com/jbdalido/smg/engine"
 	"github.com/jbdalido/smg/utils"
 )
@@ -23,7 +29,7 @@ func main() {
 	cliApp := cli.App{
 		Name:    "smg",
 		Usage:   "Run and Build docker - https://smuggler.io",
-		Version: "0.5.2",
+		Version: engine.VERSION,
 		Action:  cli.ShowAppHelp,
 		Writer:  os.Stdout,
 	}
@@ -40,6 +46,11 @@ func main() {
 			Usage:  "Config file to use",
 			EnvVar: "SMG_CONFIG",
 		},
+		cli.DurationFlag{
+			Name:  "stop-timeout",
+			Value: engine.STOP_TIMEOUT,
+			Usage: "Time given to containers to stop when interrupted, before being killed",
+		},
 	}
 
 	buildFlags := []cli.Flag{
@@ -66,12 +77,26 @@ func main() {
 		},
 		cli.BoolFlag{
 			Name:  "delete, D",
-			Usage: "Delete images created after a successful build",
+			Usage: "Delete images created after a successful build and deploy",
 		},
 		cli.StringFlag{
 			Name:  "tag, t",
 			Usage: "Force both the action used for the build, and the image tag",
 		},
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "",
+			Usage: "Environment overlay to apply before building",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Value: &cli.StringSlice{},
+			Usage: "Set a build arg (KEY=VALUE, or KEY to take it from the environment), overrides the build_args of the smg file",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "Write a JSON manifest of the build (images, digests, git, duration) to this file",
+		},
 	}
 
 	runFlags := []cli.Flag{
@@ -91,7 +116,20 @@ func main() {
 		cli.StringFlag{
 			Name:  "env, e",
 			Value: "default",
-			Usage: "Environment (commands or dockerfiles) to use for the run",
+			Usage: "Environments (commands or dockerfiles) to use for the run, comma separated to run several of them",
+		},
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "Run all the environments of the smg file",
+		},
+		cli.IntFlag{
+			Name:  "parallel, P",
+			Value: 1,
+			Usage: "Number of environments running at the same time",
+		},
+		cli.StringFlag{
+			Name:  "matrix-filter, m",
+			Usage: "Run the variants of the matrix matching all the given axes (ex: image=debian:*,PYTHON=3.5)",
 		},
 		cli.StringSliceFlag{
 			Name:  "override, o",
@@ -106,6 +144,173 @@ func main() {
 			Name:  "shared-folder, S",
 			Usage: "Use a shared-folder with the main container instead of copying the context under /data",
 		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Stop the run if it lasts longer than this duration (ex: 30m), overrides the timeout of the smg file",
+		},
+		cli.StringFlag{
+			Name:  "report, r",
+			Usage: "Run and time the commands one by one, and write their results to a junit (.xml) or json (.json) file, junit and json write report.xml and report.json",
+		},
+	}
+
+	deployFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "start, s",
+			Value: "smg.yml",
+			Usage: "Specify a different file to use for your smg run (default: smg.yml)",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
+		cli.StringFlag{
+			Name:  "tag, t",
+			Usage: "Force both the build used for the deploy, and the image tag",
+		},
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "",
+			Usage: "Environment overlay to apply before deploying",
+		},
+	}
+
+	psFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "Show stopped containers too",
+		},
+		cli.StringFlag{
+			Name:  "app",
+			Usage: "Only show containers of this application",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output as json",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
+	}
+
+	cleanFlags := []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Show what would be removed without removing anything",
+		},
+		cli.DurationFlag{
+			Name:  "older-than",
+			Value: 0,
+			Usage: "Only remove leftovers older than this duration (ex: 2h)",
+		},
+		cli.BoolFlag{
+			Name:  "keepalive, k",
+			Usage: "Keep containers of runs started with --keepalive",
+		},
+		cli.BoolFlag{
+			Name:  "running",
+			Usage: "Also remove running containers",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
+	}
+
+	logsFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "start, s",
+			Value: "smg.yml",
+			Usage: "Specify a different file to use for your smg run (default: smg.yml)",
+		},
+		cli.StringFlag{
+			Name:  "run, r",
+			Usage: "Run id to show the logs of (default: latest run)",
+		},
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "Follow log output",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Show logs since a duration (ex: 10m) or a RFC3339 date",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Value: "all",
+			Usage: "Number of lines to show from the end of the logs",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "Show timestamps",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
+	}
+
+	shellFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "start, s",
+			Value: "smg.yml",
+			Usage: "Specify a different file to use for your smg run (default: smg.yml)",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache, n",
+			Usage: "Disable the use of docker cache during run and build with provided dockerfiles",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "default",
+			Usage: "Environment (commands or dockerfiles) to open the shell in",
+		},
+		cli.StringFlag{
+			Name:  "shell",
+			Value: "/bin/sh",
+			Usage: "Shell to start in the controller",
+		},
+		cli.BoolFlag{
+			Name:  "keepalive, k",
+			Usage: "Keep containers alive after the shell exits",
+		},
+		cli.BoolFlag{
+			Name:  "shared-folder, S",
+			Usage: "Use a shared-folder with the main container instead of copying the context under /data",
+		},
+	}
+
+	execFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "start, s",
+			Value: "smg.yml",
+			Usage: "Specify a different file to use for your smg run (default: smg.yml)",
+		},
+		cli.StringFlag{
+			Name:  "run, r",
+			Usage: "Run id of the container (default: latest run)",
+		},
+		cli.BoolFlag{
+			Name:  "interactive, i",
+			Usage: "Keep stdin attached to the command",
+		},
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "Allocate a tty for the command",
+		},
+		cli.BoolFlag{
+			Name:  "it",
+			Usage: "Same as -i -t, for interactive sessions",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "Verbose Mode",
+		},
 	}
 
 	cliApp.HideVersion = true
@@ -123,15 +328,99 @@ func main() {
 			Flags:  buildFlags,
 			Action: CmdBuild,
 		},
+		cli.Command{
+			Name:   "deploy",
+			Usage:  "Deploy an already built image with the deploy targets of the build",
+			Flags:  deployFlags,
+			Action: CmdDeploy,
+		},
+		cli.Command{
+			Name:    "ps",
+			Aliases: []string{"status"},
+			Usage:   "List containers created by smuggler runs",
+			Flags:   psFlags,
+			Action:  CmdPs,
+		},
+		cli.Command{
+			Name:   "clean",
+			Usage:  "Remove containers, images, networks and build directories left by smuggler runs",
+			Flags:  cleanFlags,
+			Action: CmdClean,
+		},
+		cli.Command{
+			Name:      "logs",
+			Usage:     "Show the logs of the containers of a run",
+			ArgsUsage: "[service...]",
+			Flags:     logsFlags,
+			Action:    CmdLogs,
+		},
+		cli.Command{
+			Name:   "shell",
+			Usage:  "Open an interactive shell in the controller of an environment, with its services running",
+			Flags:  shellFlags,
+			Action: CmdShell,
+		},
+		cli.Command{
+			Name:      "exec",
+			Usage:     "Run a command in a live service or controller container of a run",
+			ArgsUsage: "<service> -- <command> [arguments...]",
+			Flags:     execFlags,
+			Action:    CmdExec,
+		},
 	}
 
+	// Commands exit with their own code, errors left
+	// are the ones of the command line
 	err := cliApp.Run(os.Args)
 	if err != nil {
-		log.Fatalf("oups")
+		log.Errorf("%s", err)
+		os.Exit(engine.EXIT_CONFIG)
 	}
 }
 
-func Init(c *cli.Context) error {
+// wait waits for the end of the command, a first signal stops
+// the containers gracefully and exits once they are cleaned up,
+// a second one kills the containers and exits immediately
+func wait() error {
+	select {
+	case err := <-endChannel:
+		return exit(err)
+	case sig := <-killChannel:
+		log.Warnf("--> Received %s, stopping containers (again to kill them)", sig)
+	}
+
+	go eng.Interrupt()
+
+	// The command ends once it removed its containers, with
+	// --keepalive they are left running and it may never end,
+	// it gets a moment to write its reports
+	var grace <-chan time.Time
+	if eng.App != nil && eng.App.KeepAlive {
+		grace = time.After(engine.LOGS_TIMEOUT)
+	}
+
+	select {
+	case <-endChannel:
+	case <-grace:
+	case sig := <-killChannel:
+		log.Warnf("--> Received %s, killing containers", sig)
+		eng.Kill()
+	}
+	return cli.NewExitError("", engine.EXIT_INTERRUPTED)
+}
+
+// exit logs the error and returns it with the exit code of
+// smg, a failed run exits with the code of the controller
+func exit(err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Errorf("%s", err)
+	return cli.NewExitError("", engine.ExitCode(err))
+}
+
+// InitEngine starts the engine without any smuggler file
+func InitEngine(c *cli.Context) (*engine.Config, error) {
 
 	utils.InitLogger(c.Bool("verbose"))
 	killChannel = make(chan os.Signal, 1)
@@ -140,13 +429,28 @@ func Init(c *cli.Context) error {
 	// Start by checking if config exist
 	cfg, err := engine.NewConfig(c.GlobalString("config"), c.GlobalString("docker"))
 	if err != nil {
-		return fmt.Errorf("Could not start smuggler with adapter %s: %s", c.GlobalString("docker"), err)
+		return nil, &engine.ExitError{Code: engine.EXIT_CONFIG, Err: fmt.Errorf("Could not start smuggler with adapter %s: %s", c.GlobalString("docker"), err)}
 	}
 
 	// Start the engine with the right adapter
 	eng, err = engine.New(cfg)
 	if err != nil {
-		return fmt.Errorf("%s: %s", c.GlobalString("docker"), err)
+		return nil, &engine.ExitError{Code: engine.EXIT_CONFIG, Err: fmt.Errorf("%s: %s", c.GlobalString("docker"), err)}
+	}
+
+	return cfg, nil
+}
+
+func Init(c *cli.Context) error {
+
+	cfg, err := InitEngine(c)
+	if err != nil {
+		return err
+	}
+
+	buildArgs, err := engine.ParseBuildArgs(c.StringSlice("build-arg"))
+	if err != nil {
+		return &engine.ExitError{Code: engine.EXIT_CONFIG, Err: err}
 	}
 
 	// Setup the application
@@ -157,6 +461,12 @@ func Init(c *cli.Context) error {
 		Uptodate:      c.Bool("last"),
 		NoCache:       c.Bool("no-cache"),
 		KeepAlive:     c.Bool("keepalive"),
+		StopTimeout:   c.GlobalDuration("stop-timeout"),
+		Report:        engine.ReportPath(c.String("report")),
+		ForceTimeout:  c.Duration("timeout"),
+		MatrixFilter:  c.String("matrix-filter"),
+		BuildArgs:     buildArgs,
+		Output:        c.String("output"),
 	}
 
 	// FIXME : setup overrides
@@ -165,11 +475,11 @@ func Init(c *cli.Context) error {
 	// Either if it's a build or a run we need to init smuggler
 	err = eng.Init(smgapp)
 	if err != nil {
-		return fmt.Errorf("Init failed with smuggler file, %s", err)
+		return &engine.ExitError{Code: engine.EXIT_CONFIG, Err: fmt.Errorf("Init failed with smuggler file, %s", err)}
 	}
 
-	// catch the CTRL-C
-	signal.Notify(killChannel, os.Interrupt)
+	// catch the CTRL-C, and the signals of CI runners
+	signal.Notify(killChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	return nil
 }
@@ -177,46 +487,175 @@ func Init(c *cli.Context) error {
 func CmdBuild(c *cli.Context) error {
 	err := Init(c)
 	if err != nil {
-		log.Fatalf("%s", err)
-		return err
+		return exit(err)
+	}
+	go func() {
+		endChannel <- eng.Build(c.Bool("push"), c.Bool("delete"), c.String("tag"), c.String("env"))
+	}()
+	return wait()
+}
+
+func CmdRun(c *cli.Context) error {
+	err := Init(c)
+	if err != nil {
+		return exit(err)
 	}
+
+	var envs []string
+	if c.Bool("all") {
+		if c.IsSet("env") {
+			return exit(&engine.ExitError{Code: engine.EXIT_CONFIG, Err: fmt.Errorf("--all and --env can't be used together")})
+		}
+		envs = eng.App.RunnableEnvironments()
+	} else {
+		for _, env := range strings.Split(c.String("env"), ",") {
+			if env = strings.TrimSpace(env); env != "" {
+				envs = append(envs, env)
+			}
+		}
+	}
+
 	go func() {
-		endChannel <- eng.Build(c.Bool("push"), c.Bool("delete"), c.String("tag"))
+		endChannel <- eng.RunAll(envs, c.Int("parallel"))
+	}()
+	return wait()
+}
+
+func CmdDeploy(c *cli.Context) error {
+	err := Init(c)
+	if err != nil {
+		return exit(err)
+	}
+	if c.String("env") != "" {
+		eng.App.ApplyEnvironment(c.String("env"))
+	}
+	go func() {
+		endChannel <- eng.DeployTag(c.String("tag"))
+	}()
+	return wait()
+}
+
+func CmdPs(c *cli.Context) error {
+	_, err := InitEngine(c)
+	if err != nil {
+		return exit(err)
+	}
+
+	containers, err := eng.Ps(c.Bool("all"), c.String("app"))
+	if err != nil {
+		return exit(err)
+	}
+
+	if c.Bool("json") {
+		if containers == nil {
+			containers = []engine.ContainerStatus{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(containers)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tNAME\tAPP\tENV\tRUN\tROLE\tBRANCH\tSTATE\tPORTS\tCREATED")
+	for _, ct := range containers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s ago\n",
+			ct.ID, ct.Name, ct.App, ct.Env, ct.RunID, ct.Role, ct.Branch, ct.State,
+			strings.Join(ct.Ports, ", "), units.HumanDuration(time.Since(ct.Created)))
+	}
+	return w.Flush()
+}
+
+func CmdClean(c *cli.Context) error {
+	_, err := InitEngine(c)
+	if err != nil {
+		return exit(err)
+	}
+
+	err = eng.Clean(engine.CleanOptions{
+		DryRun:    c.Bool("dry-run"),
+		OlderThan: c.Duration("older-than"),
+		KeepAlive: c.Bool("keepalive"),
+		Running:   c.Bool("running"),
+	})
+	if err != nil {
+		return exit(err)
+	}
+	return nil
+}
+
+func CmdLogs(c *cli.Context) error {
+	err := Init(c)
+	if err != nil {
+		return exit(err)
+	}
+	go func() {
+		endChannel <- eng.Logs(engine.LogsOptions{
+			RunID:      c.String("run"),
+			Services:   c.Args(),
+			Follow:     c.Bool("follow"),
+			Since:      c.String("since"),
+			Tail:       c.String("tail"),
+			Timestamps: c.Bool("timestamps"),
+		})
 	}()
 	select {
 	case err := <-endChannel:
-		{
-			if err != nil {
-				log.Fatalf("%s", err)
-				return err
-			}
+		if err != nil {
+			return exit(err)
 		}
 	case <-killChannel:
-		eng.Stop()
 	}
-
 	return nil
 }
 
-func CmdRun(c *cli.Context) error {
+func CmdShell(c *cli.Context) error {
 	err := Init(c)
 	if err != nil {
-		log.Fatalf("%s", err)
-		return err
+		return exit(err)
 	}
 	go func() {
-		endChannel <- eng.Run(c.String("env"))
+		endChannel <- eng.Shell(c.String("env"), strings.Fields(c.String("shell")))
+	}()
+	return wait()
+}
+
+func CmdExec(c *cli.Context) error {
+	err := Init(c)
+	if err != nil {
+		return exit(err)
+	}
+
+	args := c.Args()
+	if len(args) < 2 {
+		return exit(&engine.ExitError{Code: engine.EXIT_CONFIG, Err: fmt.Errorf("Usage: smg exec <service> -- <command> [arguments...]")})
+	}
+	cmd := args[1:]
+	if cmd[0] == "--" {
+		cmd = cmd[1:]
+	}
+
+	code := 0
+	go func() {
+		var err error
+		code, err = eng.Exec(engine.ExecOptions{
+			RunID:       c.String("run"),
+			Service:     args[0],
+			Cmd:         cmd,
+			Interactive: c.Bool("interactive") || c.Bool("it"),
+			Tty:         c.Bool("tty") || c.Bool("it"),
+		})
+		endChannel <- err
 	}()
 	select {
 	case err := <-endChannel:
-		{
-			if err != nil {
-				log.Fatalf("%s", err)
-				return err
-			}
+		if err != nil {
+			return exit(err)
 		}
 	case <-killChannel:
-		eng.Stop()
+		return cli.NewExitError("", engine.EXIT_INTERRUPTED)
+	}
+
+	// The exit code of the command is the one of smg
+	if code != 0 {
+		return cli.NewExitError("", code)
 	}
 	return nil
 }
@@ -2,11 +2,17 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 type Git struct {
@@ -14,7 +20,10 @@ type Git struct {
 	LastCommit *Commit
 	Repository string
 	Branch     string
-	Tag        []string
+	// Tag are the tags of the last commit,
+	// AllTags the ones of the repository
+	Tag     []string
+	AllTags []string
 }
 
 type Commit struct {
@@ -42,31 +51,163 @@ func NewGit(p string) (*Git, error) {
 	if g.Branch != "" {
 		commit := path.Clean(p) + "/.git/refs/heads/" + g.Branch
 		t, err := OpenFileAndRegexp(commit, "(.*)")
-		if err != nil {
+		if err == nil {
+			g.LastCommit.ID = t[0]
+		} else {
+			// The branch can be packed too
+			g.LastCommit.ID = packedRef(path.Clean(p)+"/.git/packed-refs", "refs/heads/"+g.Branch)
+		}
+		if len(g.LastCommit.ID) < 9 {
 			return g, nil
 		}
-		g.LastCommit.ID = t[0]
-		g.LastCommit.Short = t[0][:9]
+		g.LastCommit.Short = g.LastCommit.ID[:9]
 	} else {
 		g.LastCommit.ID = s[0]
 		g.LastCommit.Short = s[0][:9]
 	}
 
-	tags, err := ioutil.ReadDir(path.Clean(p) + "/.git/refs/tags/")
+	// Loose tags have their own file under refs/tags, tags
+	// like release/1.0 are in sub directories. Annotated tags
+	// point to a tag object, peeled to get its commit
+	root := path.Clean(p) + "/.git/refs/tags"
+	filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(file, root+"/"))
+		b, _ := OpenAndReadFile(file)
+		sha := strings.TrimSpace(string(b))
+		if sha != g.LastCommit.ID {
+			sha = peelTag(path.Clean(p), name, sha)
+		}
+		g.addTag(name, sha != "" && sha == g.LastCommit.ID)
+		return nil
+	})
+
+	// Others are packed, after a clone or a gc
+	g.readPackedTags(path.Clean(p) + "/.git/packed-refs")
+
+	return g, nil
+
+}
+
+// addTag adds a tag of the repository, and
+// of the last commit if head is true
+func (g *Git) addTag(name string, head bool) {
+	if !contains(g.AllTags, name) {
+		g.AllTags = append(g.AllTags, name)
+	}
+	if head && !contains(g.Tag, name) {
+		g.Tag = append(g.Tag, name)
+	}
+}
+
+// readPackedTags reads the tags of packed-refs, the commit
+// of an annotated tag is on the ^ line following it
+func (g *Git) readPackedTags(file string) {
+	f, err := os.Open(file)
 	if err != nil {
-		return g, nil
+		return
 	}
-	if len(tags) > 0 {
-		for _, tag := range tags {
-			b, _ := OpenAndReadFile(path.Clean(p) + "/.git/refs/tags/" + tag.Name())
-			if string(b)[:9] == g.LastCommit.Short {
-				g.Tag = append(g.Tag, tag.Name())
+	defer f.Close()
+
+	last := ""
+	head := g.LastCommit.ID
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "^") {
+			if last != "" && head != "" && line[1:] == head {
+				g.addTag(last, true)
 			}
+			continue
 		}
+
+		last = ""
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			continue
+		}
+		last = strings.TrimPrefix(fields[1], "refs/tags/")
+		g.addTag(last, head != "" && fields[0] == head)
 	}
+}
 
-	return g, nil
+// peelTag returns the commit a tag points to, following
+// annotated tags. Only loose objects are read, a tag that
+// can't be peeled is left on its own sha
+func peelTag(p string, name string, sha string) string {
+	for i := 0; i < 8 && len(sha) == 40; i++ {
+		f, err := os.Open(p + "/.git/objects/" + sha[:2] + "/" + sha[2:])
+		if err != nil {
+			// Packed objects are not read
+			log.Debugf("Cannot peel tag %s, object %s is not loose", name, sha)
+			return sha
+		}
+		r, err := zlib.NewReader(f)
+		if err != nil {
+			f.Close()
+			log.Debugf("Cannot peel tag %s, object %s: %s", name, sha, err)
+			return sha
+		}
+		b, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			log.Debugf("Cannot peel tag %s, object %s: %s", name, sha, err)
+			return sha
+		}
+		if !bytes.HasPrefix(b, []byte("tag ")) {
+			// A commit
+			return sha
+		}
 
+		// tag <size>\x00object <sha>\ntype commit\n...
+		object := ""
+		if n := bytes.IndexByte(b, 0); n >= 0 {
+			for _, line := range strings.Split(string(b[n+1:]), "\n") {
+				if strings.HasPrefix(line, "object ") {
+					object = strings.TrimPrefix(line, "object ")
+					break
+				}
+			}
+		}
+		if object == "" {
+			log.Debugf("Cannot peel tag %s, no object in %s", name, sha)
+			return sha
+		}
+		sha = object
+	}
+	return sha
+}
+
+// packedRef returns the commit of ref in packed-refs
+func packedRef(file string, ref string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+func contains(l []string, v string) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
 
 func OpenFileAndRegexp(path, rex string) ([]string, error) {
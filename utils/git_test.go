@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=smg", "GIT_AUTHOR_EMAIL=smg@localhost",
+		"GIT_COMMITTER_NAME=smg", "GIT_COMMITTER_EMAIL=smg@localhost")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s %s", args, err, out)
+	}
+}
+
+func TestNewGitLooseTags(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "smg-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git(t, dir, "init", "-q")
+	git(t, dir, "checkout", "-q", "-b", "master")
+	git(t, dir, "commit", "-q", "--allow-empty", "-m", "first")
+	git(t, dir, "tag", "-a", "v1.4.1", "-m", "v1.4.1")
+	git(t, dir, "commit", "-q", "--allow-empty", "-m", "second")
+	git(t, dir, "tag", "-a", "v1.4.2", "-m", "v1.4.2")
+	git(t, dir, "tag", "release/1.4")
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(g.Tag, "v1.4.2") || !contains(g.Tag, "release/1.4") || contains(g.Tag, "v1.4.1") {
+		t.Errorf("Expected v1.4.2 and release/1.4 on the last commit, got %v", g.Tag)
+	}
+	if len(g.AllTags) != 3 {
+		t.Errorf("Expected 3 tags in the repository, got %v", g.AllTags)
+	}
+}
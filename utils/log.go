@@ -5,6 +5,7 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"io"
+	"sync"
 )
 
 var Verbose bool
@@ -29,10 +30,33 @@ func IsVerbose() bool {
 
 type StdPrefixed struct {
 	Prefix string
-	buf    *bytes.Buffer
+	// Force prefixes lines even outside of a terminal
+	Force bool
+	buf   *bytes.Buffer
+	// Flush can be called while the stream is written
+	lock sync.Mutex
+}
+
+// Lines of prefixed writers are printed one at a time
+var stdLock sync.Mutex
+
+// NewStdPrefixed returns a writer prefixing every line,
+// used to multiplex the output of several containers
+func NewStdPrefixed(prefix string) *StdPrefixed {
+	return &StdPrefixed{
+		Prefix: prefix,
+		Force:  true,
+		buf:    bytes.NewBuffer([]byte("")),
+	}
 }
 
 func (s *StdPrefixed) Write(p []byte) (n int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.write(p)
+}
+
+func (s *StdPrefixed) write(p []byte) (n int, err error) {
 	n, err = s.buf.Write(p)
 	if err != nil {
 		return 0, err
@@ -41,17 +65,42 @@ func (s *StdPrefixed) Write(p []byte) (n int, err error) {
 	for {
 		line, err := s.buf.ReadString('\n')
 		if err == io.EOF {
+			// Keep the partial line for the next write
+			s.buf.WriteString(line)
 			break
 		}
 		if err != nil {
 			return 0, err
 		}
-		if log.IsTerminal() {
+		stdLock.Lock()
+		if s.Force {
+			fmt.Printf("%s %s", s.Prefix, line)
+		} else if log.IsTerminal() {
 			fmt.Printf("%s\t%s", s.Prefix, line)
 		} else {
 			fmt.Print(line)
 		}
+		stdLock.Unlock()
 	}
 
 	return len(p), nil
 }
+
+// Flush prints the last line even if it's not terminated
+func (s *StdPrefixed) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.buf.Len() > 0 {
+		s.write([]byte("\n"))
+	}
+}
+
+// Flush flushes w if it keeps an unterminated line,
+// once the stream written to it has ended
+func Flush(w io.Writer) {
+	if f, ok := w.(interface {
+		Flush()
+	}); ok {
+		f.Flush()
+	}
+}
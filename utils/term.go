@@ -0,0 +1,86 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package utils
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// TermState is the state of a terminal before going raw
+type TermState struct {
+	termios syscall.Termios
+}
+
+type winsize struct {
+	Height uint16
+	Width  uint16
+	x      uint16
+	y      uint16
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal tells if the file descriptor is a terminal
+func IsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, ioctlGetTermios, unsafe.Pointer(&termios)) == nil
+}
+
+// MakeRaw puts the terminal in raw mode and
+// returns its previous state to restore it
+func MakeRaw(fd uintptr) (*TermState, error) {
+	var state TermState
+	err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&state.termios))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := state.termios
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	err = ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// RestoreTerminal puts back the terminal in its previous state
+func RestoreTerminal(fd uintptr, state *TermState) error {
+	if state == nil {
+		return nil
+	}
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&state.termios))
+}
+
+// GetWinsize returns the height and width of the terminal
+func GetWinsize(fd uintptr) (int, int, error) {
+	var ws winsize
+	err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Height), int(ws.Width), nil
+}
+
+// NotifyResize relays terminal resizes to the channel
+func NotifyResize(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
@@ -0,0 +1,35 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// TermState is the state of a terminal before going raw
+type TermState struct{}
+
+// IsTerminal tells if the file descriptor is a terminal
+func IsTerminal(fd uintptr) bool {
+	return false
+}
+
+// MakeRaw is not supported on this platform
+func MakeRaw(fd uintptr) (*TermState, error) {
+	return nil, fmt.Errorf("Raw terminal is not supported on this platform")
+}
+
+// RestoreTerminal is not supported on this platform
+func RestoreTerminal(fd uintptr, state *TermState) error {
+	return nil
+}
+
+// GetWinsize is not supported on this platform
+func GetWinsize(fd uintptr) (int, int, error) {
+	return 0, 0, fmt.Errorf("Terminal size is not supported on this platform")
+}
+
+// NotifyResize is not supported on this platform
+func NotifyResize(c chan os.Signal) {}